@@ -0,0 +1,517 @@
+// Package html renders the tokens produced by the tokenizer package as HTML.
+package html
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"oversoul/godown/renderer"
+	"oversoul/godown/tokenizer"
+)
+
+// Options controls the generated markup.
+type Options struct {
+	// XHTML closes void elements (`<br />`, `<hr />`, `<img />`) so the
+	// output is well-formed XML. HTML5 void elements are used otherwise.
+	XHTML bool
+	// Unsafe writes the raw HTML of the document as it is, and the URLs of
+	// links and images whatever their scheme. The HTML is escaped otherwise,
+	// so that it shows as text, or left out with DropHTML, and the URLs which
+	// may run scripts are left empty.
+	Unsafe   bool
+	DropHTML bool
+}
+
+var escaper = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	`"`, "&quot;",
+)
+
+// Escape replaces the characters that have a special meaning in HTML text
+// and attribute values with their entities.
+func Escape(s string) string {
+	return escaper.Replace(s)
+}
+
+var headingLevels = map[tokenizer.TokenType]int{
+	tokenizer.Heading1: 1,
+	tokenizer.Heading2: 2,
+	tokenizer.Heading3: 3,
+	tokenizer.Heading4: 4,
+	tokenizer.Heading5: 5,
+	tokenizer.Heading6: 6,
+}
+
+type nodeRenderer struct {
+	opts Options
+}
+
+// New returns a renderer with the HTML functions registered for every token
+// type. Register replaces the rendering of single token types:
+//
+//	r := html.New(html.Options{})
+//	r.Register(tokenizer.Link, func(w io.Writer, t *tokenizer.Token, entering bool, ctx *renderer.Context) (renderer.WalkStatus, error) {
+//		...
+//	})
+//	r.Render(os.Stdout, tokens)
+func New(opts Options) *renderer.Renderer {
+	nr := &nodeRenderer{opts: opts}
+	r := renderer.New()
+	for ttype := range headingLevels {
+		r.Register(ttype, nr.heading)
+	}
+	r.Register(tokenizer.Paragraph, nr.paragraph)
+	r.Register(tokenizer.Blockquote, nr.blockquote)
+	r.Register(tokenizer.Hr, nr.hr)
+	r.Register(tokenizer.CodeBloc, nr.codeBlock)
+	r.Register(tokenizer.HTMLBlock, nr.html)
+	r.Register(tokenizer.UnorderedList, nr.list)
+	r.Register(tokenizer.OrderedList, nr.list)
+	r.Register(tokenizer.UnorderedListItem, nr.listItem)
+	r.Register(tokenizer.OrderedListItem, nr.listItem)
+	r.Register(tokenizer.Footnotes, nr.footnotes)
+	r.Register(tokenizer.FootnoteDefinition, nr.footnoteDefinition)
+	r.Register(tokenizer.FootnoteRef, nr.footnoteRef)
+	r.Register(tokenizer.Table, nr.table)
+	r.Register(tokenizer.TableRow, nr.tableRow)
+	r.Register(tokenizer.TableCell, nr.tableCell)
+	r.Register(tokenizer.Text, nr.text)
+	r.Register(tokenizer.Strong, nr.tag("strong"))
+	r.Register(tokenizer.Emphasis, nr.tag("em"))
+	r.Register(tokenizer.Strikethrough, nr.tag("del"))
+	r.Register(tokenizer.Highlight, nr.tag("mark"))
+	r.Register(tokenizer.Subscript, nr.tag("sub"))
+	r.Register(tokenizer.Superscript, nr.tag("sup"))
+	r.Register(tokenizer.CodeSpan, nr.codeSpan)
+	r.Register(tokenizer.HTMLInline, nr.html)
+	r.Register(tokenizer.Link, nr.link)
+	r.Register(tokenizer.Image, nr.image)
+	r.Register(tokenizer.SoftBreak, nr.lineBreak)
+	r.Register(tokenizer.HardBreak, nr.lineBreak)
+	return r
+}
+
+// Render writes the HTML representation of tokens to w.
+func Render(w io.Writer, tokens []*tokenizer.Token, opts Options) error {
+	return New(opts).Render(w, tokens)
+}
+
+// RenderString returns the HTML representation of tokens.
+func RenderString(tokens []*tokenizer.Token, opts Options) string {
+	var b strings.Builder
+	Render(&b, tokens, opts)
+	return b.String()
+}
+
+func write(w io.Writer, parts ...string) error {
+	for _, part := range parts {
+		if _, err := io.WriteString(w, part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (nr *nodeRenderer) void(tag string) string {
+	if nr.opts.XHTML {
+		return tag + " />"
+	}
+	return tag + ">"
+}
+
+// value returns the escaped value of tokens whose content isn't parsed into
+// children, such as hand-built ones.
+func value(t *tokenizer.Token) string {
+	if len(t.Children) > 0 {
+		return ""
+	}
+	return Escape(t.Value)
+}
+
+func isListItem(t *tokenizer.Token) bool {
+	return t != nil && (t.Ttype == tokenizer.UnorderedListItem || t.Ttype == tokenizer.OrderedListItem)
+}
+
+func (nr *nodeRenderer) heading(w io.Writer, t *tokenizer.Token, entering bool, ctx *renderer.Context) (renderer.WalkStatus, error) {
+	level := headingLevels[t.Ttype]
+	if entering {
+		id := ""
+		if slug, _ := t.Attrs["id"].(string); slug != "" {
+			id = ` id="` + Escape(slug) + `"`
+		}
+		return renderer.WalkContinue, write(w, fmt.Sprintf("<h%d%s>", level, id), value(t))
+	}
+	return renderer.WalkContinue, write(w, fmt.Sprintf("</h%d>\n", level))
+}
+
+func (nr *nodeRenderer) paragraph(w io.Writer, t *tokenizer.Token, entering bool, ctx *renderer.Context) (renderer.WalkStatus, error) {
+	if inTightList(ctx) {
+		return nr.tightParagraph(w, t, entering, ctx)
+	}
+	if !entering {
+		// The links back to the references end the last paragraph of a footnote.
+		if parent := ctx.Parent(); isFootnote(parent) && parent.Children[len(parent.Children)-1] == t {
+			return renderer.WalkContinue, write(w, backrefs(parent), "</p>\n")
+		}
+		return renderer.WalkContinue, write(w, "</p>\n")
+	}
+	// The checkbox of a loose task list item starts its first paragraph.
+	box := ""
+	if parent := ctx.Parent(); isListItem(parent) && parent.Children[0] == t {
+		box = nr.checkbox(parent)
+	}
+	return renderer.WalkContinue, write(w, "<p>", box, value(t))
+}
+
+// isTight reports whether the paragraphs of the items of list are written
+// without `<p>` tags, which is the case unless its `tight` attribute is false.
+func isTight(list *tokenizer.Token) bool {
+	if list == nil {
+		return true
+	}
+	tight, ok := list.Attrs["tight"].(bool)
+	return !ok || tight
+}
+
+// inTightList reports whether the token being rendered is a block of an item
+// of a tight list.
+func inTightList(ctx *renderer.Context) bool {
+	ancestors := ctx.Ancestors()
+	if len(ancestors) < 2 || !isListItem(ancestors[len(ancestors)-1]) {
+		return false
+	}
+	return isTight(ancestors[len(ancestors)-2])
+}
+
+// tightParagraph writes the content of a paragraph of a tight list item,
+// followed by a line break when blocks come after it.
+func (nr *nodeRenderer) tightParagraph(w io.Writer, t *tokenizer.Token, entering bool, ctx *renderer.Context) (renderer.WalkStatus, error) {
+	if entering {
+		return renderer.WalkContinue, write(w, value(t))
+	}
+	if siblings := ctx.Siblings(); siblings[len(siblings)-1] != t {
+		return renderer.WalkContinue, write(w, "\n")
+	}
+	return renderer.WalkContinue, nil
+}
+
+func isFootnote(t *tokenizer.Token) bool {
+	return t != nil && t.Ttype == tokenizer.FootnoteDefinition
+}
+
+// footnoteID returns the id of the n-th reference to the footnote number,
+// which the footnote links back to.
+func footnoteID(number any, n int) string {
+	if n > 1 {
+		return fmt.Sprintf("fnref-%v-%d", number, n)
+	}
+	return fmt.Sprintf("fnref-%v", number)
+}
+
+func (nr *nodeRenderer) footnoteRef(w io.Writer, t *tokenizer.Token, entering bool, ctx *renderer.Context) (renderer.WalkStatus, error) {
+	if !entering {
+		return renderer.WalkContinue, nil
+	}
+	number := t.Attrs["number"]
+	n, _ := t.Attrs["reference"].(int)
+	return renderer.WalkSkipChildren, write(w, fmt.Sprintf(`<sup class="footnote-ref"><a href="#fn-%v" id="%s">%v</a></sup>`, number, footnoteID(number, n), number))
+}
+
+func (nr *nodeRenderer) footnotes(w io.Writer, t *tokenizer.Token, entering bool, ctx *renderer.Context) (renderer.WalkStatus, error) {
+	if entering {
+		return renderer.WalkContinue, write(w, "<section class=\"footnotes\">\n<ol>\n")
+	}
+	return renderer.WalkContinue, write(w, "</ol>\n</section>\n")
+}
+
+// footnoteDefinition writes a footnote as an item of the footnotes section.
+// Its links back to the references end its last paragraph, or come after its
+// content when it doesn't end with a paragraph.
+func (nr *nodeRenderer) footnoteDefinition(w io.Writer, t *tokenizer.Token, entering bool, ctx *renderer.Context) (renderer.WalkStatus, error) {
+	if entering {
+		return renderer.WalkContinue, write(w, fmt.Sprintf("<li id=\"fn-%v\">\n", t.Attrs["number"]))
+	}
+	if len(t.Children) == 0 || t.Children[len(t.Children)-1].Ttype != tokenizer.Paragraph {
+		return renderer.WalkContinue, write(w, "<p>", strings.TrimPrefix(backrefs(t), " "), "</p>\n</li>\n")
+	}
+	return renderer.WalkContinue, write(w, "</li>\n")
+}
+
+// backrefs returns the links from a footnote back to each of its references.
+func backrefs(footnote *tokenizer.Token) string {
+	number := footnote.Attrs["number"]
+	count, _ := footnote.Attrs["references"].(int)
+	var b strings.Builder
+	for n := 1; n <= count; n++ {
+		fmt.Fprintf(&b, ` <a href="#%s" class="footnote-backref">↩</a>`, footnoteID(number, n))
+	}
+	return b.String()
+}
+
+func (nr *nodeRenderer) blockquote(w io.Writer, t *tokenizer.Token, entering bool, ctx *renderer.Context) (renderer.WalkStatus, error) {
+	if entering {
+		return renderer.WalkContinue, write(w, "<blockquote>\n")
+	}
+	return renderer.WalkContinue, write(w, "</blockquote>\n")
+}
+
+func (nr *nodeRenderer) hr(w io.Writer, t *tokenizer.Token, entering bool, ctx *renderer.Context) (renderer.WalkStatus, error) {
+	if !entering {
+		return renderer.WalkContinue, nil
+	}
+	return renderer.WalkSkipChildren, write(w, nr.void("<hr"), "\n")
+}
+
+func (nr *nodeRenderer) codeBlock(w io.Writer, t *tokenizer.Token, entering bool, ctx *renderer.Context) (renderer.WalkStatus, error) {
+	if !entering {
+		return renderer.WalkContinue, nil
+	}
+
+	var b strings.Builder
+	b.WriteString("<pre" + codeAttributes(t) + "><code")
+	if language, _ := t.Attrs["language"].(string); language != "" {
+		b.WriteString(` class="language-` + Escape(language) + `"`)
+	}
+	b.WriteString(">" + Escape(t.Value))
+	if t.Value != "" {
+		b.WriteString("\n")
+	}
+	b.WriteString("</code></pre>\n")
+	return renderer.WalkSkipChildren, write(w, b.String())
+}
+
+// codeAttributes returns the attributes of the pre element of a code block:
+// its `id` and `class`, the others becoming `data-` attributes.
+func codeAttributes(t *tokenizer.Token) string {
+	attributes, _ := t.Attrs["attributes"].(map[string]string)
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		name := key
+		if key != "id" && key != "class" {
+			name = "data-" + key
+		}
+		b.WriteString(" " + Escape(name) + `="` + Escape(attributes[key]) + `"`)
+	}
+	return b.String()
+}
+
+func listTag(t *tokenizer.Token) string {
+	if t.Ttype == tokenizer.OrderedList || t.Ttype == tokenizer.OrderedListItem {
+		return "ol"
+	}
+	return "ul"
+}
+
+func (nr *nodeRenderer) list(w io.Writer, t *tokenizer.Token, entering bool, ctx *renderer.Context) (renderer.WalkStatus, error) {
+	if !entering {
+		return renderer.WalkContinue, write(w, "</", listTag(t), ">\n")
+	}
+	if start, ok := t.Attrs["start"].(int); ok && start != 1 {
+		return renderer.WalkContinue, write(w, fmt.Sprintf("<%s start=\"%d\">\n", listTag(t), start))
+	}
+	return renderer.WalkContinue, write(w, "<", listTag(t), ">\n")
+}
+
+// listItem renders a list item. Its blocks start on their own line, except a
+// first paragraph written without tags in a tight list.
+func (nr *nodeRenderer) listItem(w io.Writer, t *tokenizer.Token, entering bool, ctx *renderer.Context) (renderer.WalkStatus, error) {
+	if !entering {
+		return renderer.WalkContinue, write(w, "</li>\n")
+	}
+	if len(t.Children) > 0 && !isTight(ctx.Parent()) {
+		return renderer.WalkContinue, write(w, "<li>\n")
+	}
+	if len(t.Children) > 0 && t.Children[0].Ttype != tokenizer.Paragraph {
+		return renderer.WalkContinue, write(w, "<li>", nr.checkbox(t), "\n")
+	}
+	return renderer.WalkContinue, write(w, "<li>", nr.checkbox(t), value(t))
+}
+
+// checkbox returns the disabled checkbox of a task list item, followed by a
+// space, or nothing for other items.
+func (nr *nodeRenderer) checkbox(item *tokenizer.Token) string {
+	checked, ok := item.Attrs["checked"].(bool)
+	if !ok {
+		return ""
+	}
+
+	attrs := ` disabled`
+	if checked {
+		attrs = ` checked disabled`
+	}
+	if nr.opts.XHTML {
+		attrs = ` disabled="disabled"`
+		if checked {
+			attrs = ` checked="checked" disabled="disabled"`
+		}
+	}
+	return nr.void(`<input type="checkbox"`+attrs) + " "
+}
+
+func isHeader(row *tokenizer.Token) bool {
+	header, _ := row.Attrs["header"].(bool)
+	return header
+}
+
+func (nr *nodeRenderer) table(w io.Writer, t *tokenizer.Token, entering bool, ctx *renderer.Context) (renderer.WalkStatus, error) {
+	if entering {
+		return renderer.WalkContinue, write(w, "<table>\n")
+	}
+	if n := len(t.Children); n > 0 && !isHeader(t.Children[n-1]) {
+		return renderer.WalkContinue, write(w, "</tbody>\n</table>\n")
+	}
+	return renderer.WalkContinue, write(w, "</table>\n")
+}
+
+// tableRow renders a row, in the head of the table for the header row and in
+// its body otherwise.
+func (nr *nodeRenderer) tableRow(w io.Writer, t *tokenizer.Token, entering bool, ctx *renderer.Context) (renderer.WalkStatus, error) {
+	if isHeader(t) {
+		if entering {
+			return renderer.WalkContinue, write(w, "<thead>\n<tr>\n")
+		}
+		return renderer.WalkContinue, write(w, "</tr>\n</thead>\n")
+	}
+
+	if !entering {
+		return renderer.WalkContinue, write(w, "</tr>\n")
+	}
+	if siblings := ctx.Siblings(); siblings[0] == t || isHeader(siblings[0]) && siblings[1] == t {
+		return renderer.WalkContinue, write(w, "<tbody>\n<tr>\n")
+	}
+	return renderer.WalkContinue, write(w, "<tr>\n")
+}
+
+func (nr *nodeRenderer) tableCell(w io.Writer, t *tokenizer.Token, entering bool, ctx *renderer.Context) (renderer.WalkStatus, error) {
+	tag := "td"
+	if row := ctx.Parent(); row != nil && isHeader(row) {
+		tag = "th"
+	}
+	if !entering {
+		return renderer.WalkContinue, write(w, "</", tag, ">\n")
+	}
+	if align, _ := t.Attrs["align"].(string); align != "" {
+		return renderer.WalkContinue, write(w, "<", tag, ` align="`, Escape(align), `">`, value(t))
+	}
+	return renderer.WalkContinue, write(w, "<", tag, ">", value(t))
+}
+
+func (nr *nodeRenderer) text(w io.Writer, t *tokenizer.Token, entering bool, ctx *renderer.Context) (renderer.WalkStatus, error) {
+	if !entering {
+		return renderer.WalkContinue, nil
+	}
+	return renderer.WalkContinue, write(w, Escape(t.Value))
+}
+
+// tag returns a function wrapping the children of a token in an element.
+func (nr *nodeRenderer) tag(name string) renderer.NodeFunc {
+	return func(w io.Writer, t *tokenizer.Token, entering bool, ctx *renderer.Context) (renderer.WalkStatus, error) {
+		if entering {
+			return renderer.WalkContinue, write(w, "<", name, ">")
+		}
+		return renderer.WalkContinue, write(w, "</", name, ">")
+	}
+}
+
+// html writes raw HTML as it is in unsafe mode, escaped otherwise, an escaped
+// block being a paragraph.
+func (nr *nodeRenderer) html(w io.Writer, t *tokenizer.Token, entering bool, ctx *renderer.Context) (renderer.WalkStatus, error) {
+	if !entering || nr.opts.DropHTML && !nr.opts.Unsafe {
+		return renderer.WalkSkipChildren, nil
+	}
+
+	block := t.Ttype == tokenizer.HTMLBlock
+	switch {
+	case nr.opts.Unsafe && block:
+		return renderer.WalkSkipChildren, write(w, t.Value, "\n")
+	case nr.opts.Unsafe:
+		return renderer.WalkSkipChildren, write(w, t.Value)
+	case block:
+		return renderer.WalkSkipChildren, write(w, "<p>", Escape(t.Value), "</p>\n")
+	}
+	return renderer.WalkSkipChildren, write(w, Escape(t.Value))
+}
+
+func (nr *nodeRenderer) codeSpan(w io.Writer, t *tokenizer.Token, entering bool, ctx *renderer.Context) (renderer.WalkStatus, error) {
+	if !entering {
+		return renderer.WalkContinue, nil
+	}
+	return renderer.WalkSkipChildren, write(w, "<code>", Escape(t.Value), "</code>")
+}
+
+func (nr *nodeRenderer) link(w io.Writer, t *tokenizer.Token, entering bool, ctx *renderer.Context) (renderer.WalkStatus, error) {
+	if !entering {
+		return renderer.WalkContinue, write(w, "</a>")
+	}
+	url, _ := t.Attrs["url"].(string)
+	return renderer.WalkContinue, write(w, `<a href="`, Escape(nr.safeURL(url)), `"`, title(t), `>`, value(t))
+}
+
+func (nr *nodeRenderer) image(w io.Writer, t *tokenizer.Token, entering bool, ctx *renderer.Context) (renderer.WalkStatus, error) {
+	if !entering {
+		return renderer.WalkContinue, nil
+	}
+	src, _ := t.Attrs["src"].(string)
+	alt, _ := t.Attrs["alt"].(string)
+	return renderer.WalkSkipChildren, write(w, nr.void(`<img src="`+Escape(nr.safeURL(src))+`" alt="`+Escape(alt)+`"`+title(t)))
+}
+
+// safeImages are the data URLs allowed in safe mode.
+var safeImages = []string{"data:image/gif;", "data:image/jpeg;", "data:image/png;", "data:image/webp;"}
+
+// safeURL returns url, or "" outside of unsafe mode when it may run a script:
+// a `javascript:`, `vbscript:` or `file:` URL, or a `data:` one but for
+// images. Browsers ignore the spaces and control characters in a scheme.
+func (nr *nodeRenderer) safeURL(url string) string {
+	if nr.opts.Unsafe {
+		return url
+	}
+	normalized := strings.ToLower(strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, url))
+	for _, scheme := range []string{"javascript:", "vbscript:", "file:"} {
+		if strings.HasPrefix(normalized, scheme) {
+			return ""
+		}
+	}
+	if strings.HasPrefix(normalized, "data:") {
+		for _, image := range safeImages {
+			if strings.HasPrefix(normalized, image) {
+				return url
+			}
+		}
+		return ""
+	}
+	return url
+}
+
+// title returns the title attribute of a link or an image, if it has one.
+func title(t *tokenizer.Token) string {
+	if title, _ := t.Attrs["title"].(string); title != "" {
+		return ` title="` + Escape(title) + `"`
+	}
+	return ""
+}
+
+func (nr *nodeRenderer) lineBreak(w io.Writer, t *tokenizer.Token, entering bool, ctx *renderer.Context) (renderer.WalkStatus, error) {
+	if !entering {
+		return renderer.WalkContinue, nil
+	}
+	if t.Ttype == tokenizer.HardBreak {
+		return renderer.WalkContinue, write(w, nr.void("<br"), "\n")
+	}
+	return renderer.WalkContinue, write(w, "\n")
+}
@@ -0,0 +1,280 @@
+package html
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"oversoul/godown/renderer"
+	"oversoul/godown/tokenizer"
+)
+
+func render(content string, opts Options) string {
+	return RenderString(tokenizer.NewParser(content).Tokenize(), opts)
+}
+
+func TestHeadings(t *testing.T) {
+	out := render("# Hello\n###### World", Options{})
+	expected := "<h1 id=\"hello\">Hello</h1>\n<h6 id=\"world\">World</h6>\n"
+	if out != expected {
+		t.Errorf("Headings not rendered. `%s`", out)
+	}
+}
+
+func TestParagraphWithSpans(t *testing.T) {
+	out := render("Some *italic* and **bold** [link](https://example.com)", Options{})
+	expected := "<p>Some <em>italic</em> and <strong>bold</strong> <a href=\"https://example.com\">link</a></p>\n"
+	if out != expected {
+		t.Errorf("Paragraph not rendered. `%s`", out)
+	}
+}
+
+func TestEscaping(t *testing.T) {
+	out := render("a < b & \"c\"", Options{})
+	expected := "<p>a &lt; b &amp; &quot;c&quot;</p>\n"
+	if out != expected {
+		t.Errorf("Text not escaped. `%s`", out)
+	}
+}
+
+func TestEscapesAndEntities(t *testing.T) {
+	out := render("\\*a\\* \\<b\\> &amp;lt; &copy; &quot;[c](/d?e=1&amp;f=\\\" \"&lt;t&gt;\")", Options{})
+	expected := "<p>*a* &lt;b&gt; &amp;lt; © &quot;<a href=\"/d?e=1&amp;f=&quot;\" title=\"&lt;t&gt;\">c</a></p>\n"
+	if out != expected {
+		t.Errorf("Text not escaped again. `%s`", out)
+	}
+}
+
+func TestRawHTML(t *testing.T) {
+	content := "<div class=\"note\">\n*a*\n</div>\n\nb <kbd>*c*</kbd>"
+	cases := map[Options]string{
+		{}:                             "<p>&lt;div class=&quot;note&quot;&gt;\n*a*\n&lt;/div&gt;</p>\n<p>b &lt;kbd&gt;<em>c</em>&lt;/kbd&gt;</p>\n",
+		{Unsafe: true}:                 "<div class=\"note\">\n*a*\n</div>\n<p>b <kbd><em>c</em></kbd></p>\n",
+		{DropHTML: true}:               "<p>b <em>c</em></p>\n",
+		{Unsafe: true, DropHTML: true}: "<div class=\"note\">\n*a*\n</div>\n<p>b <kbd><em>c</em></kbd></p>\n",
+	}
+	for opts, expected := range cases {
+		if out := render(content, opts); out != expected {
+			t.Errorf("%+v: raw HTML not rendered. `%s`", opts, out)
+		}
+	}
+}
+
+func TestDangerousURLs(t *testing.T) {
+	content := "[a](javascript:alert`1`) <javascript:alert(2)> [b](VBScript:x) [c]( <java\tscript:y>)\n" +
+		"![d](data:text/html,x) ![e](data:image/png;base64,AA) [f](file:///etc/passwd) [g](/javascript:z)"
+	expected := `<p><a href="">a</a> <a href="">javascript:alert(2)</a> <a href="">b</a> <a href="">c</a>` + "\n" +
+		`<img src="" alt="d"> <img src="data:image/png;base64,AA" alt="e"> <a href="">f</a> <a href="/javascript:z">g</a></p>` + "\n"
+	if out := render(content, Options{}); out != expected {
+		t.Errorf("Dangerous URLs should be left empty `%s`", out)
+	}
+	if out := render("[a](javascript:alert`1`)", Options{Unsafe: true}); out != "<p><a href=\"javascript:alert`1`\">a</a></p>\n" {
+		t.Errorf("URLs should be kept in unsafe mode `%s`", out)
+	}
+}
+
+func TestCodeBlockLanguage(t *testing.T) {
+	out := render("```go\nif a < b {}\n```", Options{})
+	expected := "<pre><code class=\"language-go\">if a &lt; b {}\n</code></pre>\n"
+	if out != expected {
+		t.Errorf("Code block not rendered. `%s`", out)
+	}
+}
+
+func TestCodeBlockAttributes(t *testing.T) {
+	out := render("```{.go #main .numbered linenos=true title=\"<main>.go\"}\nfunc main() {}\n```", Options{})
+	expected := "<pre class=\"numbered\" id=\"main\" data-linenos=\"true\" data-title=\"&lt;main&gt;.go\">" +
+		"<code class=\"language-go\">func main() {}\n</code></pre>\n"
+	if out != expected {
+		t.Errorf("Code block attributes not rendered. `%s`", out)
+	}
+}
+
+func TestVoidElements(t *testing.T) {
+	content := "---\n![alt](img.png)"
+
+	out := render(content, Options{})
+	expected := "<hr>\n<p><img src=\"img.png\" alt=\"alt\"></p>\n"
+	if out != expected {
+		t.Errorf("HTML5 void elements not rendered. `%s`", out)
+	}
+
+	out = render(content, Options{XHTML: true})
+	expected = "<hr />\n<p><img src=\"img.png\" alt=\"alt\" /></p>\n"
+	if out != expected {
+		t.Errorf("XHTML void elements not rendered. `%s`", out)
+	}
+}
+
+func TestBlockquote(t *testing.T) {
+	out := render("> Hello", Options{})
+	expected := "<blockquote>\n<p>Hello</p>\n</blockquote>\n"
+	if out != expected {
+		t.Errorf("Blockquote not rendered. `%s`", out)
+	}
+}
+
+func TestNestedBlockquote(t *testing.T) {
+	out := render("> # Title\n>> *nested*", Options{})
+	expected := "<blockquote>\n<h1 id=\"title\">Title</h1>\n<blockquote>\n<p><em>nested</em></p>\n</blockquote>\n</blockquote>\n"
+	if out != expected {
+		t.Errorf("Nested blockquote not rendered. `%s`", out)
+	}
+}
+
+func TestNestedUnorderedList(t *testing.T) {
+	out := render("- First\n  - Nested\n- Second", Options{})
+	expected := "<ul>\n<li>First\n<ul>\n<li>Nested</li>\n</ul>\n</li>\n<li>Second</li>\n</ul>\n"
+	if out != expected {
+		t.Errorf("Nested list not rendered. `%s`", out)
+	}
+}
+
+func TestLooseList(t *testing.T) {
+	out := render("- a\n\n  b\n- **c**", Options{})
+	expected := "<ul>\n<li>\n<p>a</p>\n<p>b</p>\n</li>\n<li>\n<p><strong>c</strong></p>\n</li>\n</ul>\n"
+	if out != expected {
+		t.Errorf("Loose list not rendered. `%s`", out)
+	}
+}
+
+func TestTaskList(t *testing.T) {
+	out := render("- [ ] todo\n- [x] done", Options{})
+	expected := "<ul>\n<li><input type=\"checkbox\" disabled> todo</li>\n<li><input type=\"checkbox\" checked disabled> done</li>\n</ul>\n"
+	if out != expected {
+		t.Errorf("Task list not rendered. `%s`", out)
+	}
+
+	out = render("1. [x] done\n\n2. [ ] todo", Options{XHTML: true})
+	expected = "<ol>\n<li>\n<p><input type=\"checkbox\" checked=\"checked\" disabled=\"disabled\" /> done</p>\n</li>\n" +
+		"<li>\n<p><input type=\"checkbox\" disabled=\"disabled\" /> todo</p>\n</li>\n</ol>\n"
+	if out != expected {
+		t.Errorf("Loose task list not rendered. `%s`", out)
+	}
+}
+
+func TestTable(t *testing.T) {
+	out := render("| a | b |\n| :-: | --- |\n| *c* | d \\| e |", Options{})
+	expected := "<table>\n<thead>\n<tr>\n<th align=\"center\">a</th>\n<th>b</th>\n</tr>\n</thead>\n" +
+		"<tbody>\n<tr>\n<td align=\"center\"><em>c</em></td>\n<td>d | e</td>\n</tr>\n</tbody>\n</table>\n"
+	if out != expected {
+		t.Errorf("Table not rendered. `%s`", out)
+	}
+
+	out = render("a | b\n-|-", Options{})
+	expected = "<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n</table>\n"
+	if out != expected {
+		t.Errorf("Table without body not rendered. `%s`", out)
+	}
+}
+
+func TestOrderedList(t *testing.T) {
+	out := render("1. First *item*\n2. Second", Options{})
+	expected := "<ol>\n<li>First <em>item</em></li>\n<li>Second</li>\n</ol>\n"
+	if out != expected {
+		t.Errorf("Ordered list not rendered. `%s`", out)
+	}
+}
+
+func TestNestedOrderedList(t *testing.T) {
+	out := render("3) a\n   1. b\n   - c\n4) d", Options{})
+	expected := "<ol start=\"3\">\n<li>a\n<ol>\n<li>b</li>\n</ol>\n<ul>\n<li>c</li>\n</ul>\n</li>\n<li>d</li>\n</ol>\n"
+	if out != expected {
+		t.Errorf("Nested ordered list not rendered. `%s`", out)
+	}
+}
+
+func TestOverrideLink(t *testing.T) {
+	r := New(Options{})
+	defaultLink := r.Func(tokenizer.Link)
+	r.Register(tokenizer.Link, func(w io.Writer, token *tokenizer.Token, entering bool, ctx *renderer.Context) (renderer.WalkStatus, error) {
+		if entering {
+			io.WriteString(w, `<span class="external">`)
+		}
+		status, err := defaultLink(w, token, entering, ctx)
+		if !entering {
+			io.WriteString(w, "</span>")
+		}
+		return status, err
+	})
+
+	var b strings.Builder
+	tokens := tokenizer.NewParser("See [docs](https://example.com) **now**").Tokenize()
+	if err := r.Render(&b, tokens); err != nil {
+		t.Fatal(err)
+	}
+	expected := "<p>See <span class=\"external\"><a href=\"https://example.com\">docs</a></span> <strong>now</strong></p>\n"
+	if b.String() != expected {
+		t.Errorf("Link override not applied. `%s`", b.String())
+	}
+}
+
+func TestNestedSpans(t *testing.T) {
+	out := render("# A **strong *and em*** [*link*](u) **open", Options{})
+	expected := "<h1 id=\"a-strong-and-em-link-open\">A <strong>strong <em>and em</em></strong> <a href=\"u\"><em>link</em></a> **open</h1>\n"
+	if out != expected {
+		t.Errorf("Nested spans not rendered. `%s`", out)
+	}
+}
+
+func TestExtensionSpans(t *testing.T) {
+	ext, _ := tokenizer.ParseExtensions("all")
+	tokens := tokenizer.NewParser("~~a~~ ==b== c~d~ e^f^").WithExtensions(ext).Tokenize()
+	out := RenderString(tokens, Options{})
+	expected := "<p><del>a</del> <mark>b</mark> c<sub>d</sub> e<sup>f</sup></p>\n"
+	if out != expected {
+		t.Errorf("Extension spans not rendered. `%s`", out)
+	}
+}
+
+func TestCodeSpan(t *testing.T) {
+	out := render("`<a> *b*` and `` ` ``", Options{})
+	expected := "<p><code>&lt;a&gt; *b*</code> and <code>`</code></p>\n"
+	if out != expected {
+		t.Errorf("Code span not rendered. `%s`", out)
+	}
+}
+
+func TestAutolinks(t *testing.T) {
+	tokens := tokenizer.NewParser("<a@b.co> or www.c.com/?d&e").WithExtensions(tokenizer.ExtLinkify).Tokenize()
+	out := RenderString(tokens, Options{})
+	expected := "<p><a href=\"mailto:a@b.co\">a@b.co</a> or <a href=\"http://www.c.com/?d&amp;e\">www.c.com/?d&amp;e</a></p>\n"
+	if out != expected {
+		t.Errorf("Autolinks not rendered. `%s`", out)
+	}
+}
+
+func TestReferenceLinks(t *testing.T) {
+	content := "[link][a] and ![image][a]\n\n[A]: /url?a&b \"A <title>\""
+	expected := "<p><a href=\"/url?a&amp;b\" title=\"A &lt;title&gt;\">link</a> and <img src=\"/url?a&amp;b\" alt=\"image\" title=\"A &lt;title&gt;\"></p>\n"
+	if out := render(content, Options{}); out != expected {
+		t.Errorf("Reference links not rendered. `%s`", out)
+	}
+}
+
+func TestFootnotes(t *testing.T) {
+	content := "Text[^a], again[^a] and[^b].\n\n[^b]: Second\n[^a]: First\n\n    > quote"
+	expected := "<p>Text<sup class=\"footnote-ref\"><a href=\"#fn-1\" id=\"fnref-1\">1</a></sup>, " +
+		"again<sup class=\"footnote-ref\"><a href=\"#fn-1\" id=\"fnref-1-2\">1</a></sup> " +
+		"and<sup class=\"footnote-ref\"><a href=\"#fn-2\" id=\"fnref-2\">2</a></sup>.</p>\n" +
+		"<section class=\"footnotes\">\n<ol>\n" +
+		"<li id=\"fn-1\">\n<p>First</p>\n<blockquote>\n<p>quote</p>\n</blockquote>\n" +
+		"<p><a href=\"#fnref-1\" class=\"footnote-backref\">↩</a> <a href=\"#fnref-1-2\" class=\"footnote-backref\">↩</a></p>\n</li>\n" +
+		"<li id=\"fn-2\">\n<p>Second <a href=\"#fnref-2\" class=\"footnote-backref\">↩</a></p>\n</li>\n" +
+		"</ol>\n</section>\n"
+	if out := render(content, Options{}); out != expected {
+		t.Errorf("Footnotes not rendered. `%s`", out)
+	}
+}
+
+func TestLineBreaks(t *testing.T) {
+	content := "soft\nbreak  \nhard"
+	expected := "<p>soft\nbreak<br>\nhard</p>\n"
+	if out := render(content, Options{}); out != expected {
+		t.Errorf("Line breaks not rendered. `%s`", out)
+	}
+	expected = "<p>soft\nbreak<br />\nhard</p>\n"
+	if out := render(content, Options{XHTML: true}); out != expected {
+		t.Errorf("XHTML line breaks not rendered. `%s`", out)
+	}
+}
@@ -1,56 +1,90 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
-	"oversoul/godown/tokenizer"
+	"strings"
 )
 
-type Token struct {
-	*tokenizer.Token
+// version is overridden at build time with
+// `-ldflags "-X main.version=v1.2.3"`.
+var version = "dev"
+
+const usage = `usage: godown <command> [options] [file...]
+
+commands:
+  render    convert markdown to another format (default command)
+  ast       print the token tree as indented JSON
+  version   print the version
+  help      print this help
+
+Files are concatenated in order; without files, or with "-", the input is
+read from stdin. Options may follow the files, the arguments after "--"
+all being files. Run "godown render -h" to list the render options.
+`
+
+// Exit codes.
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// usageError is reported with exitUsage instead of exitError.
+type usageError struct {
+	msg string
 }
 
-func (root *Token) Render() {
-	switch root.Ttype {
-	case tokenizer.Heading1:
-		fmt.Print("# ")
-	case tokenizer.Heading2:
-		fmt.Print("## ")
-	case tokenizer.Heading3:
-		fmt.Print("### ")
-	case tokenizer.Heading4:
-		fmt.Print("#### ")
-	case tokenizer.Heading5:
-		fmt.Print("##### ")
-	case tokenizer.Heading6:
-		fmt.Print("###### ")
-	}
-	fmt.Println(root.Value)
-	for _, item := range root.Children {
-		t := Token{item}
-		t.Render()
-	}
+func (e *usageError) Error() string {
+	return e.msg
 }
 
 func main() {
-	// content := "# Welcome to StackEdit!\n\nHi! I'm your first Markdown.\n\n- first item\n- second item\n"
-	content, err := os.ReadFile("example.md")
-	if err != nil {
-		panic(err)
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	command := "render"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command = args[0]
+		args = args[1:]
 	}
-	tokens := tokenizer.NewParser(string(content)).Tokenize()
 
-	// for _, token := range tokens {
-	// 	t := Token{token}
-	// 	t.Render()
-	// }
+	var err error
+	switch command {
+	case "render":
+		err = runRender(args, "render", stdin, stdout, stderr)
+	case "ast":
+		err = runRender(args, "ast", stdin, stdout, stderr)
+	case "version":
+		fmt.Fprintf(stdout, "godown %s\n", version)
+	case "help":
+		fmt.Fprint(stdout, usage)
+	default:
+		// `godown file.md` is a shorthand for `godown render file.md`.
+		if _, statErr := os.Stat(command); statErr != nil {
+			fmt.Fprint(stderr, usage)
+			err = &usageError{fmt.Sprintf("unknown command %q", command)}
+			break
+		}
+		err = runRender(append([]string{command}, args...), "render", stdin, stdout, stderr)
+	}
 
-	data, err := json.Marshal(tokens)
-	if err != nil {
-		panic(err)
+	if err == nil {
+		return exitOK
 	}
 
-	// _ = data
-	fmt.Println(string(data))
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+
+	fmt.Fprintf(stderr, "godown: %s\n", err)
+	var usageErr *usageError
+	if errors.As(err, &usageErr) {
+		return exitUsage
+	}
+	return exitError
 }
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runWith(args []string, input string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(input), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRenderStdinToHTML(t *testing.T) {
+	code, out, _ := runWith([]string{"render"}, "# Hello")
+	if code != exitOK {
+		t.Errorf("Unexpected exit code %d", code)
+	}
+	if out != "<h1 id=\"hello\">Hello</h1>\n" {
+		t.Errorf("Unexpected output `%s`", out)
+	}
+}
+
+func TestRenderFileToOutput(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.md")
+	output := filepath.Join(dir, "out.txt")
+	if err := os.WriteFile(input, []byte("Hello *world*\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	code, _, stderr := runWith([]string{"render", "--to", "text", "-o", output, input}, "")
+	if code != exitOK {
+		t.Fatalf("Unexpected exit code %d: %s", code, stderr)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "Hello world\n" {
+		t.Errorf("Unexpected output `%s`", data)
+	}
+}
+
+func TestFlagsAfterFiles(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.md")
+	dashed := filepath.Join(dir, "--to")
+	for _, file := range []string{input, dashed} {
+		if err := os.WriteFile(file, []byte("Hello *world*\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	code, out, stderr := runWith([]string{"render", input, "--to", "text"}, "")
+	if code != exitOK || out != "Hello world\n" {
+		t.Errorf("Flags after the file should apply %d `%s` %s", code, out, stderr)
+	}
+
+	code, out, stderr = runWith([]string{"render", "--to", "text", input, "--", dashed}, "")
+	if code != exitOK || out != "Hello world\nHello world\n" {
+		t.Errorf("Arguments after -- should be files %d `%s` %s", code, out, stderr)
+	}
+}
+
+func TestExtensions(t *testing.T) {
+	input := "~~old~~ H~2~O"
+	cases := map[string]string{
+		"":                        "<p>~~old~~ H~2~O</p>\n",
+		"strikethrough":           "<p><del>old</del> H~2~O</p>\n",
+		"strikethrough,subscript": "<p><del>old</del> H<sub>2</sub>O</p>\n",
+		"all":                     "<p><del>old</del> H<sub>2</sub>O</p>\n",
+	}
+	for ext, expected := range cases {
+		code, out, stderr := runWith([]string{"render", "--ext", ext}, input)
+		if code != exitOK || out != expected {
+			t.Errorf("--ext %q: unexpected output %d `%s` %s", ext, code, out, stderr)
+		}
+	}
+}
+
+func TestFrontMatterNotRendered(t *testing.T) {
+	code, out, stderr := runWith([]string{"render"}, "---\ntitle: Post\n---\n# Post")
+	if code != exitOK || out != "<h1 id=\"post\">Post</h1>\n" {
+		t.Errorf("Unexpected output %d `%s` %s", code, out, stderr)
+	}
+}
+
+func TestFrontMatterKept(t *testing.T) {
+	input := "+++\ntitle = \"Post\" # kept as is\n+++\n# Post\n"
+	code, out, stderr := runWith([]string{"render", "--to", "markdown"}, input)
+	if code != exitOK || out != input {
+		t.Errorf("Front matter should be written back %d `%s` %s", code, out, stderr)
+	}
+
+	code, out, _ = runWith([]string{"render", "--to", "json"}, input)
+	if code != exitOK || !strings.Contains(out, `"meta":{"title":"Post"}`) || !strings.HasPrefix(out, `{"tokens":[`) {
+		t.Errorf("JSON should hold the metadata `%s`", out)
+	}
+
+	code, _, stderr = runWith([]string{"render"}, "---\ndescription: >\n  text\n---\n# Post")
+	if code != exitOK || stderr != "godown: warning: 1:1: YAML front matter doesn't parse, read as Markdown\n" {
+		t.Errorf("Invalid front matter should be reported %d %s", code, stderr)
+	}
+}
+
+func TestTableOfContents(t *testing.T) {
+	input := "[TOC]\n\n# Intro\n\n## Intro\n"
+	code, out, stderr := runWith([]string{"render", "--toc", "--slugs", "gitlab"}, input)
+	expected := "<ul>\n<li><a href=\"#intro\">Intro</a>\n<ul>\n<li><a href=\"#intro-1\">Intro</a></li>\n</ul>\n</li>\n</ul>\n" +
+		"<h1 id=\"intro\">Intro</h1>\n<h2 id=\"intro-1\">Intro</h2>\n"
+	if code != exitOK || out != expected {
+		t.Errorf("Unexpected output %d `%s` %s", code, out, stderr)
+	}
+
+	code, out, _ = runWith([]string{"render"}, input)
+	if code != exitOK || !strings.HasPrefix(out, "<p>[TOC]</p>\n") {
+		t.Errorf("The marker should be kept without --toc `%s`", out)
+	}
+}
+
+func TestUnsafeHTML(t *testing.T) {
+	input := "<div>\n*a*\n</div>\n\n<b>b</b>"
+	code, out, _ := runWith([]string{"render"}, input)
+	if code != exitOK || out != "<p>&lt;div&gt;\n*a*\n&lt;/div&gt;</p>\n<p>&lt;b&gt;b&lt;/b&gt;</p>\n" {
+		t.Errorf("Raw HTML should be escaped by default `%s`", out)
+	}
+	code, out, _ = runWith([]string{"render", "--unsafe"}, input)
+	if code != exitOK || out != "<div>\n*a*\n</div>\n<p><b>b</b></p>\n" {
+		t.Errorf("Raw HTML should be kept with --unsafe `%s`", out)
+	}
+	code, out, _ = runWith([]string{"render", "--to", "text"}, input)
+	if code != exitOK || out != "b\n" {
+		t.Errorf("Raw HTML should be left out of text `%s`", out)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	code, out, _ := runWith([]string{"version"}, "")
+	if code != exitOK || out != "godown dev\n" {
+		t.Errorf("Unexpected version output %d `%s`", code, out)
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	cases := [][]string{
+		{"frobnicate"},
+		{"render", "--to", "pdf"},
+		{"render", "--ext", "unknown"},
+		{"render", "--slugs", "wiki"},
+		{"render", "--unknown-flag"},
+	}
+	for _, args := range cases {
+		code, _, stderr := runWith(args, "")
+		if code != exitUsage {
+			t.Errorf("%v: expected usage exit code, got %d", args, code)
+		}
+		if !strings.Contains(stderr, "godown: ") {
+			t.Errorf("%v: missing error message `%s`", args, stderr)
+		}
+	}
+}
+
+func TestMissingFile(t *testing.T) {
+	code, _, stderr := runWith([]string{"render", "does-not-exist.md"}, "")
+	if code != exitError {
+		t.Errorf("Expected error exit code, got %d", code)
+	}
+	if !strings.Contains(stderr, "does-not-exist.md") {
+		t.Errorf("Error should name the file `%s`", stderr)
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	code, out, stderr := runWith([]string{"render"}, "```go\ncode")
+	if code != exitOK {
+		t.Errorf("Warnings should not fail the command, got %d", code)
+	}
+	if out != "<pre><code class=\"language-go\">code\n</code></pre>\n" {
+		t.Errorf("Unexpected output `%s`", out)
+	}
+	if stderr != "godown: warning: 1:1: code block is not closed\n" {
+		t.Errorf("Unexpected warnings `%s`", stderr)
+	}
+}
@@ -0,0 +1,511 @@
+// Package markdown writes token trees back to Markdown, so that tokenizing the
+// output yields the same tree again.
+package markdown
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"oversoul/godown/tokenizer"
+)
+
+var headingPrefixes = map[tokenizer.TokenType]string{
+	tokenizer.Heading1: "# ",
+	tokenizer.Heading2: "## ",
+	tokenizer.Heading3: "### ",
+	tokenizer.Heading4: "#### ",
+	tokenizer.Heading5: "##### ",
+	tokenizer.Heading6: "###### ",
+}
+
+// setextUnderlines maps the levels of the headings which may span several
+// lines to their underline.
+var setextUnderlines = map[tokenizer.TokenType]string{
+	tokenizer.Heading1: "=",
+	tokenizer.Heading2: "-",
+}
+
+// spanMarkers maps the spans of extensions to the delimiters around them.
+var spanMarkers = map[tokenizer.TokenType]string{
+	tokenizer.Strikethrough: "~~",
+	tokenizer.Highlight:     "==",
+	tokenizer.Subscript:     "~",
+	tokenizer.Superscript:   "^",
+}
+
+// Render writes the Markdown representation of tokens to w.
+func Render(w io.Writer, tokens []*tokenizer.Token) error {
+	_, err := io.WriteString(w, RenderString(tokens))
+	return err
+}
+
+// RenderDocument writes the Markdown representation of doc to w, starting
+// with its front matter as it was written.
+func RenderDocument(w io.Writer, doc *tokenizer.Document) error {
+	if doc.FrontMatter != "" {
+		if _, err := io.WriteString(w, doc.FrontMatter+"\n"); err != nil {
+			return err
+		}
+	}
+	return Render(w, doc.Tokens)
+}
+
+// RenderString returns the Markdown representation of tokens.
+func RenderString(tokens []*tokenizer.Token) string {
+	blocks := []string{}
+	for _, token := range tokens {
+		blocks = append(blocks, block(token))
+	}
+	if len(blocks) == 0 {
+		return ""
+	}
+	return strings.Join(blocks, "\n\n") + "\n"
+}
+
+func block(t *tokenizer.Token) string {
+	if prefix, ok := headingPrefixes[t.Ttype]; ok {
+		text := content(t)
+		// A heading spanning several lines is underlined.
+		if strings.Contains(text, "\n") {
+			return escapeLineStart(text) + "\n" + strings.Repeat(setextUnderlines[t.Ttype], 3)
+		}
+		return prefix + text
+	}
+
+	switch t.Ttype {
+	case tokenizer.Blockquote:
+		return quote(strings.TrimSuffix(RenderString(t.Children), "\n"))
+	case tokenizer.Hr:
+		// Dashes would underline a paragraph or open front matter.
+		return "***"
+	case tokenizer.CodeBloc:
+		return codeBlock(t)
+	case tokenizer.HTMLBlock:
+		return t.Value
+	case tokenizer.UnorderedList, tokenizer.OrderedList:
+		return list(t)
+	case tokenizer.Table:
+		return table(t)
+	case tokenizer.Footnotes:
+		return footnotes(t)
+	}
+	return content(t)
+}
+
+// footnotes writes the definitions of the footnotes, their blocks indented by
+// 4 columns after the first line.
+func footnotes(t *tokenizer.Token) string {
+	definitions := []string{}
+	for _, definition := range t.Children {
+		label := "[^" + definition.Value + "]:"
+		if text := indentLines(strings.TrimSuffix(RenderString(definition.Children), "\n"), "    "); text != "" {
+			label += " " + strings.TrimPrefix(text, "    ")
+		}
+		definitions = append(definitions, label)
+	}
+	return strings.Join(definitions, "\n\n")
+}
+
+// codeBlock writes a fenced code block, the fence being longer than the runs
+// of its character in the code. Tildes are used when the info string holds
+// backticks.
+func codeBlock(t *tokenizer.Token) string {
+	info, ok := t.Attrs["info"].(string)
+	if !ok {
+		info, _ = t.Attrs["language"].(string)
+	}
+	var c byte = '`'
+	if strings.Contains(info, "`") {
+		c = '~'
+	}
+	length := longestRun(t.Value, c) + 1
+	if length < 3 {
+		length = 3
+	}
+	fence := strings.Repeat(string(c), length)
+	return fence + info + "\n" + t.Value + "\n" + fence
+}
+
+// longestRun returns the length of the longest run of c in s.
+func longestRun(s string, c byte) int {
+	longest, run := 0, 0
+	for i := 0; i < len(s); i++ {
+		if s[i] != c {
+			run = 0
+			continue
+		}
+		run++
+		if run > longest {
+			longest = run
+		}
+	}
+	return longest
+}
+
+// list writes the items of a list, their content being indented to the column
+// following the marker. Blank lines separate the items and their blocks when
+// the list isn't tight.
+func list(t *tokenizer.Token) string {
+	separator := "\n"
+	if tight, ok := t.Attrs["tight"].(bool); ok && !tight {
+		separator = "\n\n"
+	}
+
+	items := []string{}
+	for i, item := range t.Children {
+		marker := itemMarker(t, i)
+		blocks := []string{}
+		for _, child := range item.Children {
+			blocks = append(blocks, block(child))
+		}
+		indent := strings.Repeat(" ", len(marker))
+		text := indentLines(strings.Join(blocks, separator), indent)
+		first := marker + checkbox(item) + strings.TrimPrefix(text, indent)
+		// Nested empty items could make a thematic break, their content
+		// then starts on the next line.
+		if line, _, _ := strings.Cut(first, "\n"); isThematicBreak(line) {
+			first = marker + checkbox(item) + "\n" + text
+		}
+		items = append(items, first)
+	}
+	return strings.Join(items, separator)
+}
+
+// isThematicBreak reports whether line is a run of at least 3 `-`, `*` or
+// `_`, possibly separated by spaces.
+func isThematicBreak(line string) bool {
+	marks := strings.NewReplacer(" ", "", "\t", "").Replace(line)
+	return len(marks) >= 3 && strings.Trim(marks, marks[:1]) == "" && strings.Contains("-*_", marks[:1])
+}
+
+// checkbox returns the checkbox of a task list item followed by a space, or
+// nothing for other items.
+func checkbox(item *tokenizer.Token) string {
+	checked, ok := item.Attrs["checked"].(bool)
+	if !ok {
+		return ""
+	}
+	if checked {
+		return "[x] "
+	}
+	return "[ ] "
+}
+
+// itemMarker returns the marker of the item i of list, followed by a space.
+func itemMarker(list *tokenizer.Token, i int) string {
+	if list.Ttype == tokenizer.UnorderedList {
+		bullet, _ := list.Attrs["bullet"].(string)
+		if bullet == "" {
+			bullet = "-"
+		}
+		return bullet + " "
+	}
+
+	number, ok := list.Children[i].Attrs["id"].(int)
+	if !ok {
+		start, ok := list.Attrs["start"].(int)
+		if !ok {
+			start = 1
+		}
+		number = start + i
+	}
+	delimiter, _ := list.Attrs["delimiter"].(string)
+	if delimiter == "" {
+		delimiter = "."
+	}
+	return fmt.Sprintf("%d%s ", number, delimiter)
+}
+
+var delimiters = map[string]string{
+	"":       "---",
+	"left":   ":---",
+	"center": ":---:",
+	"right":  "---:",
+}
+
+// table writes a table with leading and trailing pipes, the delimiter row
+// following the header row. Pipes in cells are escaped.
+func table(t *tokenizer.Token) string {
+	rows := []string{}
+	for i, row := range t.Children {
+		cells := []string{}
+		for _, cell := range row.Children {
+			cells = append(cells, strings.ReplaceAll(content(cell), "|", "\\|"))
+		}
+		rows = append(rows, "| "+strings.Join(cells, " | ")+" |")
+
+		if i == 0 {
+			aligns := []string{}
+			for _, cell := range row.Children {
+				align, _ := cell.Attrs["align"].(string)
+				aligns = append(aligns, delimiters[align])
+			}
+			rows = append(rows, "| "+strings.Join(aligns, " | ")+" |")
+		}
+	}
+	return strings.Join(rows, "\n")
+}
+
+// quote prefixes the lines of text with a quote marker.
+func quote(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if line == "" {
+			lines[i] = ">"
+		} else {
+			lines[i] = "> " + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// indentLines indents the lines of text, blank ones excepted.
+func indentLines(text string, indent string) string {
+	lines := strings.Split(text, "\n")
+	for i := range lines {
+		if lines[i] != "" {
+			lines[i] = indent + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// content writes the spans of t, or its value when it has none. The text
+// starting a line is kept from starting a block.
+func content(t *tokenizer.Token) string {
+	return delimited(t, "")
+}
+
+// delimited writes the content of t written between delim, the delimiter of
+// an emphasis or a strong.
+func delimited(t *tokenizer.Token, delim string) string {
+	if len(t.Children) == 0 {
+		return t.Value
+	}
+	var b strings.Builder
+	for i, child := range t.Children {
+		start := i == 0 && t.Ttype == tokenizer.Paragraph || i > 0 && isBreak(t.Children[i-1])
+		if child.Ttype == tokenizer.Text && start {
+			b.WriteString(escapeLineStart(escapeText(child.Value)))
+			continue
+		}
+		span(&b, child, t, delim)
+	}
+	return b.String()
+}
+
+func isBreak(t *tokenizer.Token) bool {
+	return t.Ttype == tokenizer.SoftBreak || t.Ttype == tokenizer.HardBreak
+}
+
+// isWord reports whether c belongs to a word, `_` not being emphasis between
+// such characters.
+func isWord(c byte) bool {
+	return c != ' ' && c != '\t' && c != '\n' && !isPunct(c)
+}
+
+func isPunct(c byte) bool {
+	return (c >= '!' && c <= '/') || (c >= ':' && c <= '@') || (c >= '[' && c <= '`') || (c >= '{' && c <= '~')
+}
+
+// isEntity reports whether s starts like an entity after its `&`: letters,
+// digits or `#` followed by a `;`.
+func isEntity(s string) bool {
+	end := strings.IndexByte(s, ';')
+	if end <= 0 {
+		return false
+	}
+	for i := 0; i < end; i++ {
+		if !(s[i] == '#' || s[i] >= '0' && s[i] <= '9' || s[i] >= 'a' && s[i] <= 'z' || s[i] >= 'A' && s[i] <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// escapeText escapes the characters of text which would be read as markup:
+// backslashes, backticks, brackets, `*`, `~` and `^`, `_` out of a word, `=`
+// followed by another, `!` which may be followed by a link, `<` followed by
+// text and `&` starting an entity.
+func escapeText(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		escape := false
+		switch c := text[i]; c {
+		case '\\', '`', '*', '[', ']', '~', '^':
+			escape = true
+		case '_':
+			escape = i == 0 || i == len(text)-1 || !isWord(text[i-1]) || !isWord(text[i+1])
+		case '=':
+			escape = i+1 < len(text) && text[i+1] == '='
+		case '!':
+			escape = i+1 == len(text)
+		case '<':
+			escape = i+1 == len(text) || text[i+1] != ' '
+		case '&':
+			escape = isEntity(text[i+1:])
+		}
+		if escape {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(text[i])
+	}
+	return b.String()
+}
+
+// escapeLineStart escapes the character of text which would start a block at
+// the beginning of a line: a heading, a blockquote, a list item, a setext
+// underline or a thematic break.
+func escapeLineStart(text string) string {
+	if text != "" && strings.IndexByte("#>-+=", text[0]) >= 0 {
+		return `\` + text
+	}
+	digits := 0
+	for digits < len(text) && text[digits] >= '0' && text[digits] <= '9' {
+		digits++
+	}
+	if digits > 0 && digits < len(text) && (text[digits] == '.' || text[digits] == ')') {
+		return text[:digits] + `\` + text[digits:]
+	}
+	return text
+}
+
+// escapeURL writes the destination of a link or an image, between `<` and
+// `>` when it holds spaces or is empty, a title then being able to follow it.
+func escapeURL(url string) string {
+	var b strings.Builder
+	for i := 0; i < len(url); i++ {
+		c := url[i]
+		if strings.IndexByte("()<>", c) >= 0 || c == '\\' && (i+1 == len(url) || isPunct(url[i+1])) || c == '&' && isEntity(url[i+1:]) {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	if url == "" || strings.ContainsAny(url, " \t") {
+		return "<" + b.String() + ">"
+	}
+	return b.String()
+}
+
+func span(b *strings.Builder, t *tokenizer.Token, parent *tokenizer.Token, delim string) {
+	switch t.Ttype {
+	case tokenizer.Text:
+		b.WriteString(escapeText(t.Value))
+	case tokenizer.Strong:
+		b.WriteString("**" + delimited(t, "**") + "**")
+	case tokenizer.Emphasis:
+		marker := emphasisMarker(b, t, parent, delim)
+		b.WriteString(marker + delimited(t, marker) + marker)
+	case tokenizer.Strikethrough, tokenizer.Highlight, tokenizer.Subscript, tokenizer.Superscript:
+		marker := spanMarkers[t.Ttype]
+		b.WriteString(marker + content(t) + marker)
+	case tokenizer.CodeSpan:
+		b.WriteString(codeSpan(t.Value))
+	case tokenizer.HTMLInline:
+		b.WriteString(t.Value)
+	case tokenizer.FootnoteRef:
+		b.WriteString("[^" + t.Value + "]")
+	case tokenizer.Link:
+		if autolink, _ := t.Attrs["autolink"].(bool); autolink {
+			b.WriteString("<" + t.Value + ">")
+			return
+		}
+		url, _ := t.Attrs["url"].(string)
+		fmt.Fprintf(b, "[%s](%s%s)", content(t), escapeURL(url), title(t))
+	case tokenizer.SoftBreak:
+		b.WriteString("\n")
+	case tokenizer.HardBreak:
+		b.WriteString("\\\n")
+	case tokenizer.Image:
+		src, _ := t.Attrs["src"].(string)
+		alt, _ := t.Attrs["alt"].(string)
+		fmt.Fprintf(b, "![%s](%s%s)", escapeText(alt), escapeURL(src), title(t))
+	default:
+		b.WriteString(content(t))
+	}
+}
+
+// emphasisMarker chooses the delimiter of an emphasis written after b in
+// parent, itself between delim. `***a***` is an emphasis around a strong, so
+// an emphasis touching a `*` run, such as the `**` of a strong, is written
+// with `_` to keep the runs apart, as is an emphasis holding another one, as
+// long as no word touches it.
+func emphasisMarker(b *strings.Builder, t *tokenizer.Token, parent *tokenizer.Token, delim string) string {
+	if parent == nil {
+		return "*"
+	}
+	i := 0
+	for parent.Children[i] != t {
+		i++
+	}
+	// The bytes around the emphasis, 0 when there is none.
+	var prev, next byte
+	word := false
+	if b.Len() > 0 {
+		prev = b.String()[b.Len()-1]
+		word = isWord(prev)
+	} else if delim != "" {
+		prev = delim[0]
+	}
+	if i+1 == len(parent.Children) && delim != "" {
+		next = delim[0]
+	} else if i+1 < len(parent.Children) {
+		switch sibling := parent.Children[i+1]; {
+		case sibling.Ttype == tokenizer.Strong:
+			next = '*'
+		case sibling.Ttype == tokenizer.Text && sibling.Value != "":
+			next = sibling.Value[0]
+			word = word || isWord(next)
+		}
+	}
+	if word {
+		return "*"
+	}
+	nested := false
+	for _, child := range t.Children {
+		nested = nested || child.Ttype == tokenizer.Emphasis
+	}
+	if prev == '*' || next == '*' || nested && prev != '_' && next != '_' {
+		return "_"
+	}
+	return "*"
+}
+
+// title writes the title of a link or an image after its destination, quoted
+// with `'` when it contains `"`. Reference links are written inline. The
+// backslashes which would escape the next character are escaped.
+func title(t *tokenizer.Token) string {
+	title, _ := t.Attrs["title"].(string)
+	if title == "" {
+		return ""
+	}
+	quote := byte('"')
+	if strings.Contains(title, `"`) {
+		quote = '\''
+	}
+
+	var b strings.Builder
+	b.WriteString(" " + string(quote))
+	for i := 0; i < len(title); i++ {
+		c := title[i]
+		if c == quote || c == '\\' && (i+1 == len(title) || isPunct(title[i+1])) || c == '&' && isEntity(title[i+1:]) {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	b.WriteByte(quote)
+	return b.String()
+}
+
+// codeSpan writes code between backtick runs longer than the ones it contains.
+// Spaces are added inside the runs when the code starts or ends with a
+// backtick, or with spaces on both sides which would be stripped.
+func codeSpan(code string) string {
+	fence := strings.Repeat("`", longestRun(code, '`')+1)
+	if strings.HasPrefix(code, "`") || strings.HasSuffix(code, "`") ||
+		strings.HasPrefix(code, " ") && strings.HasSuffix(code, " ") && strings.Trim(code, " ") != "" {
+		code = " " + code + " "
+	}
+	return fence + code + fence
+}
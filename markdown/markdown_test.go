@@ -0,0 +1,181 @@
+package markdown
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"oversoul/godown/tokenizer"
+)
+
+var roundTrips = []string{
+	"# Title\n\n###### Small title",
+	"Setext\n===\n\n*Two*\nlines  \n---\n\n***\n\n- - -",
+	"Hello *world* and __strong__ text",
+	"**bold *italic***",
+	"***both*** and **_strong emphasis_**",
+	"*a **b** c* and [**bold** link](url)",
+	"snake_case_name, 2 * 3 and *unclosed",
+	"wrapped *emphasis\nacross* lines  \nhard break\\\nand another",
+	"[link](https://example.com) and ![image](img.png)",
+	"<https://example.com/a)b> and <user@example.com>",
+	"[titled](/url \"a title\") ![img](i.png 'say \"hi\"')",
+	"[reference][r], [R][] and ![r]\n\n[r]: /url \"Title\"",
+	"Notes[^1] and[^b], again[^1].\n\n[^1]: One\n\n    > quoted\n\n    - list\n[^b]: Two[^c]\n[^c]:\n    ```go\n    code\n    ```",
+	"`a_b_c`, `` a ` b ``, ` `` `, `  two  `, `   ` and *`code`*",
+	"> quoted\n> lines",
+	"> # Quoted title\n>\n> - item\n>\n>> nested *quote*\nlazy line",
+	"> ```go\n> code\n> ```\n\n> another quote",
+	"---",
+	"```go\nfunc main() {\nfmt.Println(\"hi\")\n}\n```",
+	"- first\n- second\n  - nested\n    - deeper\n  more text\n- third",
+	"* Winter\n  ```jsx\n  const Snow = <Snowflake amount=20 />;\n  ```\n* Frost",
+	"1. First *item*\n2. Second item",
+	"- loose\n\n  two paragraphs\n- **item**\n\n+ other bullet\n  * nested\n\n    loose",
+	"- [ ] todo\n- [x] **done**\n  1. [X] nested\n- [] plain",
+	"Name | *Value*\n:- | -:\n| a \\| [b](c\\|d) | |\n|  |",
+	"| a |\n| - |\n\n| b |\n| - |\n| c |",
+	"-\ttab\n\t- nested\n- \n- odd\n   width",
+	"3) three\n   wrapped\n   1. nested\n   2. list\n      - mixed\n4) four\n\n1. other list",
+	"1. ```go\n   code\n   ```\n2. > quote",
+	"````{.go title=\"main.go\"}\n```\n````\n\n~~~ a`b\n~~~~\n\n    indented\n\n      code",
+	"# Title\nparagraph\n- item\n\n1. one\n\n> quote\n\n---\ntext",
+	"\\*not italic\\*, \\_a\\_, snake_case, \\[no link\\], \\`code\\`, \\\\, C:\\path and a\\",
+	"&amp;lt; &copy; &#x2014; AT&T, &hl; a < b \\<tag> == ~ ^",
+	"\\# not heading\n\\- not list\n1\\. not ordered\n\\> not quote\n\\+ \\===\n\\***",
+	"[a\\]b](/url\\)x \"t \\\"q\\\" 'x'\") ![a *b*](<my img.png> 'it\\'s') [c](&lt;d&gt; \"&amp;\")",
+	"<div>\n*raw*\n</div>\n\n<!--\n\n-->\ntext <b>*bold*</b> <br/> \\<i>\n\n- <pre>\n\n  </pre>",
+	"# \\*heading\\*\n\n- \\- item\n- 2\\) item",
+	"!\n=\n\n[!]()",
+	"0[^1]\n\n[^1]: # ",
+	"[](<> \"t\") [a](b\\ 'c\\')",
+	"- \n  - - \n\n* \n  ***",
+	"- --\n---\n\n+ 000\n  - --",
+	"<?\n",
+	"**0*0*0*",
+	"*0*_0_",
+	"**!**_0_",
+	"**0*_0_*",
+	"0*0 *0***",
+	"\x00*0***",
+	"**0*0***",
+	"- foo\n-\n- bar",
+	"1. a\n2.\n3. b",
+	"> foo\nbar\n===\n\n- a\n===",
+}
+
+// equal compares two trees. Tokens with children, such as headings and
+// links, are compared on them, their value being the source of their text,
+// escapes included.
+func equal(a, b []*tokenizer.Token) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Ttype != b[i].Ttype {
+			return false
+		}
+		if len(a[i].Children) == 0 && a[i].Value != b[i].Value {
+			return false
+		}
+		if !reflect.DeepEqual(a[i].Attrs, b[i].Attrs) {
+			return false
+		}
+		if !equal(a[i].Children, b[i].Children) {
+			return false
+		}
+	}
+	return true
+}
+
+func dump(tokens []*tokenizer.Token) string {
+	data, _ := json.Marshal(tokens)
+	return string(data)
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, content := range roundTrips {
+		tokens := tokenizer.NewParser(content).Tokenize()
+		output := RenderString(tokens)
+		again := tokenizer.NewParser(output).Tokenize()
+		if !equal(tokens, again) {
+			t.Errorf("Round trip of `%s` through `%s` changed the tree:\n%s\n%s", content, output, dump(tokens), dump(again))
+		}
+	}
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	for _, content := range roundTrips {
+		f.Add(content)
+	}
+	f.Fuzz(func(t *testing.T, content string) {
+		tokens := tokenizer.NewParser(content).Tokenize()
+		output := RenderString(tokens)
+		again := tokenizer.NewParser(output).Tokenize()
+		if !equal(tokens, again) {
+			t.Errorf("Round trip of %q through %q changed the tree:\n%s\n%s", content, output, dump(tokens), dump(again))
+		}
+	})
+}
+
+func TestRoundTripExtensions(t *testing.T) {
+	ext, _ := tokenizer.ParseExtensions("all")
+	for _, content := range []string{
+		"~~strike *this*~~, ==mark==, H~2~O and 2^10^",
+		"**~~both~~** [==link==](url)",
+		"www.example.com/a_b, <http://x.y> and a@b.co.",
+	} {
+		tokens := tokenizer.NewParser(content).WithExtensions(ext).Tokenize()
+		output := RenderString(tokens)
+		again := tokenizer.NewParser(output).WithExtensions(ext).Tokenize()
+		if !equal(tokens, again) {
+			t.Errorf("Round trip of `%s` through `%s` changed the tree:\n%s\n%s", content, output, dump(tokens), dump(again))
+		}
+	}
+}
+
+func TestRenderDocument(t *testing.T) {
+	content := "---\ntitle: Post # comment\n---\n# Post\n"
+	var b strings.Builder
+	if err := RenderDocument(&b, tokenizer.NewParser(content).Parse()); err != nil || b.String() != content {
+		t.Errorf("Front matter should be kept `%s` %v", b.String(), err)
+	}
+}
+
+func TestRenderHeading(t *testing.T) {
+	tokens := tokenizer.NewParser("## Hello world").Tokenize()
+	if output := RenderString(tokens); output != "## Hello world\n" {
+		t.Errorf("Heading not rendered. `%s`", output)
+	}
+}
+
+func TestRenderEscapes(t *testing.T) {
+	tokens := tokenizer.NewParser("\\# a\\_b\\* &amp;copy; x_y <z [u](<v w> \"&quot;\")").Tokenize()
+	expected := "\\# a_b\\* \\&copy; x_y \\<z [u](<v w> '\"')\n"
+	if output := RenderString(tokens); output != expected {
+		t.Errorf("Unexpected escapes `%s`", output)
+	}
+}
+
+func TestRenderCodeBlockFences(t *testing.T) {
+	tokens := tokenizer.NewParser("````{.md}\n```go\n````\n\n~~~ a`b\n~~~~x\n~~~\n\n    indented").Tokenize()
+	expected := "````{.md}\n```go\n````\n\n~~~~~a`b\n~~~~x\n~~~~~\n\n```\nindented\n```\n"
+	if output := RenderString(tokens); output != expected {
+		t.Errorf("Unexpected fences `%s`", output)
+	}
+}
+
+func TestRenderCodeBlock(t *testing.T) {
+	tokens := tokenizer.NewParser("```go\nx := 1\n```").Tokenize()
+	if output := RenderString(tokens); output != "```go\nx := 1\n```\n" {
+		t.Errorf("Code block not rendered. `%s`", output)
+	}
+}
+
+func TestRenderSpans(t *testing.T) {
+	tokens := tokenizer.NewParser("a *b* **c** [d](e) ![f](g)").Tokenize()
+	if output := RenderString(tokens); output != "a *b* **c** [d](e) ![f](g)\n" {
+		t.Errorf("Spans not rendered. `%s`", output)
+	}
+}
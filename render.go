@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"oversoul/godown/html"
+	"oversoul/godown/markdown"
+	"oversoul/godown/toc"
+	"oversoul/godown/tokenizer"
+)
+
+var formats = []string{"html", "json", "markdown", "text"}
+
+// runRender implements the render and ast commands, the latter always
+// writing indented JSON.
+func runRender(args []string, command string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	format := fs.String("to", "html", "output format: "+strings.Join(formats, ", "))
+	output := fs.String("o", "", "write the output to `file` instead of stdout")
+	extList := fs.String("ext", "", "comma separated `extensions` to enable ("+extensionsHelp()+")")
+	xhtml := fs.Bool("xhtml", false, "close void elements in HTML output")
+	slugs := fs.String("slugs", "github", "`style` of the heading ids: github, gitlab or pandoc")
+	withTOC := fs.Bool("toc", false, "replace [TOC] paragraphs with the table of contents")
+	unsafe := fs.Bool("unsafe", false, "write the raw HTML of the document in HTML output instead of escaping it")
+
+	files, err := parseArgs(fs, args)
+	if err != nil {
+		if err == flag.ErrHelp {
+			fmt.Fprintf(stdout, "usage: godown %s [options] [file...]\n\noptions:\n", command)
+			fs.SetOutput(stdout)
+			fs.PrintDefaults()
+			return err
+		}
+		return &usageError{err.Error()}
+	}
+
+	if command == "ast" {
+		*format = "ast"
+	} else if !isFormat(*format) {
+		return &usageError{fmt.Sprintf("unknown format %q, expected one of %s", *format, strings.Join(formats, ", "))}
+	}
+
+	ext, err := tokenizer.ParseExtensions(*extList)
+	if err != nil {
+		return &usageError{err.Error()}
+	}
+
+	style, err := tokenizer.ParseSlugStyle(*slugs)
+	if err != nil {
+		return &usageError{err.Error()}
+	}
+
+	content, err := readInput(files, stdin)
+	if err != nil {
+		return err
+	}
+	doc, diagnostics := tokenizer.NewParser(content).WithExtensions(ext).WithSlugStyle(style).ParseWithDiagnostics()
+	for _, diagnostic := range diagnostics {
+		fmt.Fprintf(stderr, "godown: warning: %s\n", diagnostic)
+	}
+	if *withTOC {
+		doc.Tokens = toc.Inject(doc.Tokens)
+	}
+
+	var out bytes.Buffer
+	if err := write(&out, *format, doc, html.Options{XHTML: *xhtml, Unsafe: *unsafe}); err != nil {
+		return err
+	}
+
+	if *output == "" || *output == "-" {
+		_, err = stdout.Write(out.Bytes())
+		return err
+	}
+	return os.WriteFile(*output, out.Bytes(), 0o644)
+}
+
+// parseArgs parses the flags found anywhere in args, before or after the file
+// names, which it returns. The arguments following `--` are all file names.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	files := []string{}
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return files, nil
+		}
+		// Parsing stops at the first file name, or after `--`.
+		if len(rest) < len(args) && args[len(args)-len(rest)-1] == "--" {
+			return append(files, rest...), nil
+		}
+		files = append(files, rest[0])
+		args = rest[1:]
+	}
+}
+
+func isFormat(format string) bool {
+	for _, name := range formats {
+		if name == format {
+			return true
+		}
+	}
+	return false
+}
+
+func extensionsHelp() string {
+	names := tokenizer.ExtensionNames()
+	if len(names) == 0 {
+		return "none available"
+	}
+	return strings.Join(append(names, "all"), ", ")
+}
+
+// readInput concatenates the given files, "-" or no files at all meaning stdin.
+func readInput(files []string, stdin io.Reader) (string, error) {
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	contents := []string{}
+	for _, file := range files {
+		var data []byte
+		var err error
+		if file == "-" {
+			data, err = io.ReadAll(stdin)
+		} else {
+			data, err = os.ReadFile(file)
+		}
+		if err != nil {
+			return "", err
+		}
+		contents = append(contents, strings.TrimSuffix(string(data), "\n"))
+	}
+
+	return strings.Join(contents, "\n\n"), nil
+}
+
+// write writes doc in format. The JSON formats hold the whole document, and
+// the Markdown one its front matter, which the others leave out.
+func write(w io.Writer, format string, doc *tokenizer.Document, opts html.Options) error {
+	switch format {
+	case "html":
+		return html.Render(w, doc.Tokens, opts)
+	case "json":
+		return json.NewEncoder(w).Encode(doc)
+	case "ast":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(doc)
+	case "markdown":
+		return markdown.RenderDocument(w, doc)
+	case "text":
+		for _, token := range doc.Tokens {
+			writeText(w, token)
+		}
+	}
+	return nil
+}
+
+// writeText writes the textual content of a block, one block per line. The
+// cells of a table row are separated by tabs.
+func writeText(w io.Writer, t *tokenizer.Token) {
+	var line strings.Builder
+	if t.Ttype == tokenizer.TableRow {
+		cells := []string{}
+		for _, cell := range t.Children {
+			var text strings.Builder
+			collectText(&text, cell)
+			cells = append(cells, text.String())
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+		return
+	}
+	collectText(&line, t)
+	if line.Len() > 0 {
+		fmt.Fprintln(w, line.String())
+	}
+	for _, child := range t.Children {
+		if !isSpan(child) {
+			writeText(w, child)
+		}
+	}
+}
+
+func collectText(b *strings.Builder, t *tokenizer.Token) {
+	switch t.Ttype {
+	case tokenizer.Image:
+		alt, _ := t.Attrs["alt"].(string)
+		b.WriteString(alt)
+		return
+	case tokenizer.CodeBloc, tokenizer.Text:
+		b.WriteString(t.Value)
+		return
+	case tokenizer.HTMLBlock, tokenizer.HTMLInline:
+		return
+	case tokenizer.SoftBreak:
+		b.WriteString(" ")
+		return
+	case tokenizer.FootnoteRef:
+		fmt.Fprintf(b, "[%v]", t.Attrs["number"])
+		return
+	case tokenizer.HardBreak:
+		b.WriteString("\n")
+		return
+	}
+	if len(t.Children) == 0 {
+		b.WriteString(t.Value)
+	}
+	for _, child := range t.Children {
+		if isSpan(child) {
+			collectText(b, child)
+		}
+	}
+}
+
+func isSpan(t *tokenizer.Token) bool {
+	switch t.Ttype {
+	case tokenizer.Text, tokenizer.Strong, tokenizer.Emphasis, tokenizer.Link, tokenizer.Image,
+		tokenizer.SoftBreak, tokenizer.HardBreak, tokenizer.Strikethrough, tokenizer.Highlight,
+		tokenizer.Subscript, tokenizer.Superscript, tokenizer.CodeSpan, tokenizer.FootnoteRef, tokenizer.HTMLInline:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+// Package renderer walks token trees and calls the function registered for
+// the type of every token, once when entering it and once when leaving it.
+// Output formats register a function per token type, callers can then replace
+// single node kinds while keeping the defaults for everything else.
+package renderer
+
+import (
+	"io"
+
+	"oversoul/godown/tokenizer"
+)
+
+// WalkStatus tells the renderer how to continue after entering a node.
+type WalkStatus int
+
+const (
+	// WalkContinue renders the children of the node.
+	WalkContinue WalkStatus = iota
+	// WalkSkipChildren skips the children, the node is still exited.
+	WalkSkipChildren
+	// WalkStop ends the rendering without exiting any node.
+	WalkStop
+)
+
+// NodeFunc renders a token. It's called with entering set before the children
+// of t are rendered, and with entering unset after them. The status returned
+// when exiting is ignored unless it's WalkStop.
+type NodeFunc func(w io.Writer, t *tokenizer.Token, entering bool, ctx *Context) (WalkStatus, error)
+
+// Context holds the state of a single rendering.
+type Context struct {
+	// Renderer is the renderer walking the tree.
+	Renderer *Renderer
+	// Data is free to use by node functions to share state.
+	Data    map[string]any
+	tokens  []*tokenizer.Token
+	parents []*tokenizer.Token
+}
+
+// Parent returns the token containing the current one, nil at the top level.
+func (ctx *Context) Parent() *tokenizer.Token {
+	if len(ctx.parents) == 0 {
+		return nil
+	}
+	return ctx.parents[len(ctx.parents)-1]
+}
+
+// Ancestors returns the tokens containing the current one, outermost first.
+func (ctx *Context) Ancestors() []*tokenizer.Token {
+	return ctx.parents
+}
+
+// Siblings returns the tokens at the same level as the current one, itself
+// included.
+func (ctx *Context) Siblings() []*tokenizer.Token {
+	if parent := ctx.Parent(); parent != nil {
+		return parent.Children
+	}
+	return ctx.tokens
+}
+
+// Renderer maps token types to the functions rendering them.
+type Renderer struct {
+	funcs    map[tokenizer.TokenType]NodeFunc
+	fallback NodeFunc
+}
+
+// New returns a renderer without any registered function, tokens are walked
+// without producing output until functions are registered.
+func New() *Renderer {
+	return &Renderer{funcs: map[tokenizer.TokenType]NodeFunc{}}
+}
+
+// Register sets the function rendering tokens of type ttype, replacing the
+// previous one.
+func (r *Renderer) Register(ttype tokenizer.TokenType, fn NodeFunc) {
+	r.funcs[ttype] = fn
+}
+
+// Func returns the function registered for ttype, or nil. It lets overrides
+// wrap the default behavior.
+func (r *Renderer) Func(ttype tokenizer.TokenType) NodeFunc {
+	return r.funcs[ttype]
+}
+
+// SetFallback sets the function used for token types without a registered
+// function.
+func (r *Renderer) SetFallback(fn NodeFunc) {
+	r.fallback = fn
+}
+
+// Render walks tokens and writes their rendering to w. It stops at the first
+// error returned by a node function.
+func (r *Renderer) Render(w io.Writer, tokens []*tokenizer.Token) error {
+	ctx := &Context{Renderer: r, Data: map[string]any{}, tokens: tokens}
+	_, err := r.walkAll(w, tokens, ctx)
+	return err
+}
+
+func (r *Renderer) walkAll(w io.Writer, tokens []*tokenizer.Token, ctx *Context) (bool, error) {
+	for _, token := range tokens {
+		if stop, err := r.walk(w, token, ctx); stop || err != nil {
+			return true, err
+		}
+	}
+	return false, nil
+}
+
+func (r *Renderer) walk(w io.Writer, t *tokenizer.Token, ctx *Context) (bool, error) {
+	fn, found := r.funcs[t.Ttype]
+	if !found {
+		fn = r.fallback
+	}
+
+	status := WalkContinue
+	if fn != nil {
+		var err error
+		if status, err = fn(w, t, true, ctx); err != nil || status == WalkStop {
+			return true, err
+		}
+	}
+
+	if status != WalkSkipChildren {
+		ctx.parents = append(ctx.parents, t)
+		stop, err := r.walkAll(w, t.Children, ctx)
+		ctx.parents = ctx.parents[:len(ctx.parents)-1]
+		if stop || err != nil {
+			return true, err
+		}
+	}
+
+	if fn == nil {
+		return false, nil
+	}
+	status, err := fn(w, t, false, ctx)
+	return status == WalkStop, err
+}
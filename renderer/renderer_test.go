@@ -0,0 +1,89 @@
+package renderer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"oversoul/godown/tokenizer"
+)
+
+func tree() []*tokenizer.Token {
+	return tokenizer.NewParser("# Title\n\nsome *text*").Tokenize()
+}
+
+// trace writes `<Type` when entering and `Type>` when exiting.
+func trace(w io.Writer, t *tokenizer.Token, entering bool, ctx *Context) (WalkStatus, error) {
+	if entering {
+		fmt.Fprintf(w, "<%s", t.Ttype)
+	} else {
+		fmt.Fprintf(w, "%s>", t.Ttype)
+	}
+	return WalkContinue, nil
+}
+
+func TestWalkOrder(t *testing.T) {
+	r := New()
+	r.SetFallback(trace)
+
+	var b strings.Builder
+	if err := r.Render(&b, tree()); err != nil {
+		t.Fatal(err)
+	}
+	expected := "<Heading1<TextText>Heading1><Paragraph<TextText><Emphasis<TextText>Emphasis>Paragraph>"
+	if b.String() != expected {
+		t.Errorf("Unexpected walk order `%s`", b.String())
+	}
+}
+
+func TestRegisteredFuncAndSkipChildren(t *testing.T) {
+	r := New()
+	r.SetFallback(trace)
+	r.Register(tokenizer.Paragraph, func(w io.Writer, t *tokenizer.Token, entering bool, ctx *Context) (WalkStatus, error) {
+		if entering {
+			io.WriteString(w, "[p]")
+		}
+		return WalkSkipChildren, nil
+	})
+
+	var b strings.Builder
+	r.Render(&b, tree())
+	if b.String() != "<Heading1<TextText>Heading1>[p]" {
+		t.Errorf("Children should be skipped `%s`", b.String())
+	}
+}
+
+func TestParent(t *testing.T) {
+	r := New()
+	parents := []string{}
+	r.Register(tokenizer.Text, func(w io.Writer, t *tokenizer.Token, entering bool, ctx *Context) (WalkStatus, error) {
+		if entering {
+			parents = append(parents, string(ctx.Parent().Ttype))
+		}
+		return WalkContinue, nil
+	})
+
+	r.Render(io.Discard, tree())
+	if strings.Join(parents, ",") != "Heading1,Paragraph,Emphasis" {
+		t.Errorf("Unexpected parents %v", parents)
+	}
+}
+
+func TestStopOnError(t *testing.T) {
+	r := New()
+	calls := 0
+	failure := errors.New("failure")
+	r.SetFallback(func(w io.Writer, t *tokenizer.Token, entering bool, ctx *Context) (WalkStatus, error) {
+		calls++
+		return WalkContinue, failure
+	})
+
+	if err := r.Render(io.Discard, tree()); err != failure {
+		t.Errorf("Error not returned %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Rendering should stop at the first error, %d calls", calls)
+	}
+}
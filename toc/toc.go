@@ -0,0 +1,106 @@
+// Package toc builds the table of contents of a document from the ids the
+// tokenizer gives its headings.
+package toc
+
+import (
+	"strings"
+
+	"oversoul/godown/tokenizer"
+)
+
+// Entry is a heading of the outline, holding the entries of the headings of
+// its section.
+type Entry struct {
+	Level    int      `json:"level"`
+	Text     string   `json:"text"`
+	ID       string   `json:"id"`
+	Children []*Entry `json:"children"`
+}
+
+// Build returns the outline of the top-level headings of tokens. A heading is
+// nested in the closest heading before it of a lower level.
+func Build(tokens []*tokenizer.Token) []*Entry {
+	outline := []*Entry{}
+	sections := []*Entry{}
+	for _, t := range tokens {
+		level := tokenizer.HeadingLevel(t)
+		if level == 0 {
+			continue
+		}
+
+		id, _ := t.Attrs["id"].(string)
+		entry := &Entry{Level: level, Text: tokenizer.PlainText(t.Children), ID: id, Children: []*Entry{}}
+		for len(sections) > 0 && sections[len(sections)-1].Level >= level {
+			sections = sections[:len(sections)-1]
+		}
+		if len(sections) == 0 {
+			outline = append(outline, entry)
+		} else {
+			parent := sections[len(sections)-1]
+			parent.Children = append(parent.Children, entry)
+		}
+		sections = append(sections, entry)
+	}
+	return outline
+}
+
+func token(ttype tokenizer.TokenType, value string, children ...*tokenizer.Token) *tokenizer.Token {
+	if children == nil {
+		children = []*tokenizer.Token{}
+	}
+	return &tokenizer.Token{Ttype: ttype, Value: value, Attrs: tokenizer.Attribute{}, Children: children}
+}
+
+// List returns the outline as a tight list of links to the headings, the
+// entries of a section being a list nested in its item. Headings without id
+// are listed without link. It returns nil for an empty outline.
+func List(outline []*Entry) *tokenizer.Token {
+	if len(outline) == 0 {
+		return nil
+	}
+
+	list := token(tokenizer.UnorderedList, "")
+	list.Attrs["tight"] = true
+	list.Attrs["bullet"] = "-"
+	for _, entry := range outline {
+		text := token(tokenizer.Text, entry.Text)
+		if entry.ID != "" {
+			link := token(tokenizer.Link, entry.Text, text)
+			link.Attrs["url"] = "#" + entry.ID
+			text = link
+		}
+		item := token(tokenizer.UnorderedListItem, "", token(tokenizer.Paragraph, "", text))
+		if nested := List(entry.Children); nested != nil {
+			item.Children = append(item.Children, nested)
+		}
+		list.Children = append(list.Children, item)
+	}
+	return list
+}
+
+// isMarker reports whether t is a paragraph made of `[TOC]` alone, in any
+// case.
+func isMarker(t *tokenizer.Token) bool {
+	return t.Ttype == tokenizer.Paragraph && len(t.Children) == 1 &&
+		t.Children[0].Ttype == tokenizer.Text && strings.EqualFold(t.Children[0].Value, "[TOC]")
+}
+
+// Inject returns tokens with the paragraphs made of a `[TOC]` marker at their
+// top level replaced by the list of their outline, or left out when there are
+// no headings.
+func Inject(tokens []*tokenizer.Token) []*tokenizer.Token {
+	list := List(Build(tokens))
+	result := []*tokenizer.Token{}
+	for _, t := range tokens {
+		if !isMarker(t) {
+			result = append(result, t)
+			continue
+		}
+		if list != nil {
+			injected := *list
+			injected.Pos = t.Pos
+			result = append(result, &injected)
+		}
+	}
+	return result
+}
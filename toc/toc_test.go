@@ -0,0 +1,56 @@
+package toc
+
+import (
+	"encoding/json"
+	"testing"
+
+	"oversoul/godown/html"
+	"oversoul/godown/tokenizer"
+)
+
+func TestBuild(t *testing.T) {
+	tokens := tokenizer.NewParser("## Before\n\n# A *b*\n\n### Deep\n\n## C\n\n> # Quoted\n\n# D").Tokenize()
+	data, _ := json.Marshal(Build(tokens))
+	expected := `[{"level":2,"text":"Before","id":"before","children":[]},` +
+		`{"level":1,"text":"A b","id":"a-b","children":[` +
+		`{"level":3,"text":"Deep","id":"deep","children":[]},` +
+		`{"level":2,"text":"C","id":"c","children":[]}]},` +
+		`{"level":1,"text":"D","id":"d","children":[]}]`
+	if string(data) != expected {
+		t.Errorf("Unexpected outline %s", data)
+	}
+}
+
+func TestList(t *testing.T) {
+	if List(nil) != nil {
+		t.Error("An empty outline should give no list")
+	}
+
+	outline := []*Entry{{Level: 1, Text: "A", ID: "a", Children: []*Entry{{Level: 2, Text: "!!!"}}}}
+	out := html.RenderString([]*tokenizer.Token{List(outline)}, html.Options{})
+	expected := "<ul>\n<li><a href=\"#a\">A</a>\n<ul>\n<li>!!!</li>\n</ul>\n</li>\n</ul>\n"
+	if out != expected {
+		t.Errorf("Unexpected list `%s`", out)
+	}
+}
+
+func TestInject(t *testing.T) {
+	tokens := Inject(tokenizer.NewParser("[toc]\n\n# A\n\n[TOC] here\n\n[TOC]").Tokenize())
+	if len(tokens) != 4 {
+		t.Fatalf("Unexpected tokens %v", tokens)
+	}
+	if tokens[0].Ttype != tokenizer.UnorderedList || tokens[3].Ttype != tokenizer.UnorderedList {
+		t.Errorf("Markers not replaced %v", tokens)
+	}
+	if tokens[0].Pos.Start.Line != 1 || tokens[3].Pos.Start.Line != 7 {
+		t.Errorf("The lists should be where the markers are %v %v", tokens[0].Pos, tokens[3].Pos)
+	}
+	if tokens[2].Ttype != tokenizer.Paragraph {
+		t.Errorf("Paragraph with text after the marker replaced %v", tokens[2])
+	}
+
+	tokens = Inject(tokenizer.NewParser("[TOC]\n\ntext").Tokenize())
+	if len(tokens) != 1 || tokens[0].Ttype != tokenizer.Paragraph {
+		t.Errorf("The marker should be left out without headings %v", tokens)
+	}
+}
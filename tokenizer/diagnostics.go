@@ -0,0 +1,86 @@
+package tokenizer
+
+import "fmt"
+
+// Diagnostic is a problem found in the source. The tokenizer recovers from
+// it, usually by keeping the offending markup as text, and reports where.
+type Diagnostic struct {
+	Pos     Point  `json:"position"`
+	Message string `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s", d.Pos.Line, d.Pos.Column, d.Message)
+}
+
+// warn records a diagnostic, the same one being reported once.
+func (p *parser) warn(pt Point, message string) {
+	diagnostic := Diagnostic{Pos: pt, Message: message}
+	for _, previous := range p.doc.diagnostics {
+		if previous == diagnostic {
+			return
+		}
+	}
+	p.doc.diagnostics = append(p.doc.diagnostics, diagnostic)
+}
+
+// parseSafely runs parser, turning a panic into a diagnostic and a refusal
+// to parse the block so the next parser is tried.
+func (p *parser) parseSafely(parser ParserFunc, index int) (tokens []*Token, skip int) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.warn(p.point(index, 0), fmt.Sprintf("internal error, block skipped: %v", r))
+			tokens, skip = nil, 0
+		}
+	}()
+	return parser(p, index)
+}
+
+// frontMatter parses the front matter starting the lines, reporting the one
+// which doesn't parse and is left as Markdown. Run safely, a panic is
+// reported and the lines are left as Markdown too.
+func (p *parser) frontMatter() (meta map[string]any, end int) {
+	if p.safe {
+		defer func() {
+			if r := recover(); r != nil {
+				p.warn(p.point(0, 0), fmt.Sprintf("internal error, front matter skipped: %v", r))
+				meta, end = nil, 0
+			}
+		}()
+	}
+	meta, end, _ = parseFrontMatter(p.lines)
+	if format := frontMatterFormat(p.lines); end == 0 && format != "" {
+		p.warn(p.point(0, 0), format+" front matter doesn't parse, read as Markdown")
+	}
+	return meta, end
+}
+
+// recoverInline, deferred while parsing the inline content found at pt, turns
+// a panic into a diagnostic, the block being left without content.
+func (p *parser) recoverInline(pt Point) {
+	if r := recover(); r != nil {
+		p.warn(pt, fmt.Sprintf("internal error, inline content skipped: %v", r))
+	}
+}
+
+// TokenizeWithDiagnostics tokenizes the content like Tokenize and returns the
+// problems found in it. It never panics: a block parser failing unexpectedly
+// is reported and the block parsed by the next parser instead, down to
+// skipping the line.
+func (p *parser) TokenizeWithDiagnostics() ([]*Token, []Diagnostic) {
+	doc, diagnostics := p.ParseWithDiagnostics()
+	return doc.Tokens, diagnostics
+}
+
+// ParseWithDiagnostics is Parse returning the problems found in the content
+// like TokenizeWithDiagnostics.
+func (p *parser) ParseWithDiagnostics() (*Document, []Diagnostic) {
+	p.doc.diagnostics = []Diagnostic{}
+	p.safe = true
+	defer func() {
+		p.safe = false
+	}()
+
+	doc := p.Parse()
+	return doc, p.doc.diagnostics
+}
@@ -0,0 +1,86 @@
+package tokenizer
+
+import (
+	"html"
+	"strings"
+)
+
+// isEscape reports whether the byte at index i of s is a backslash escaping
+// the ASCII punctuation following it.
+func isEscape(s string, i int) bool {
+	return s[i] == '\\' && i+1 < len(s) && isPunct(s[i+1])
+}
+
+// indexUnescaped returns the index of the first c in s which isn't escaped by
+// a backslash, or -1.
+func indexUnescaped(s string, c byte) int {
+	for i := 0; i < len(s); i++ {
+		if isEscape(s, i) {
+			i++
+			continue
+		}
+		if s[i] == c {
+			return i
+		}
+	}
+	return -1
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// entity decodes the entity starting at the `&` at index i of s: a named one,
+// `&copy;`, a decimal one, `&#169;`, or an hexadecimal one, `&#xA9;`. It
+// returns the text it stands for and the index following it, 0 when there's
+// no entity. Invalid code points stand for the replacement character.
+func entity(s string, i int) (string, int) {
+	start, max, valid := i+1, 32, isAlnum
+	if start < len(s) && s[start] == '#' {
+		start, max, valid = start+1, 7, isDigit
+		if start < len(s) && (s[start] == 'x' || s[start] == 'X') {
+			start, max, valid = start+1, 6, isHexDigit
+		}
+	}
+	end := start
+	for end < len(s) && end-start < max && valid(s[end]) {
+		end++
+	}
+	if end == start || end == len(s) || s[end] != ';' {
+		return "", 0
+	}
+
+	raw := s[i : end+1]
+	text := html.UnescapeString(raw)
+	// Names are matched in full: `&ampx;` only starts with an entity.
+	if text == raw || s[i+1] != '#' && strings.HasSuffix(text, ";") && raw != "&semi;" {
+		return "", 0
+	}
+	return text, end + 1
+}
+
+// unescape resolves the backslash escapes and the entities of s.
+func unescape(s string) string {
+	if !strings.ContainsAny(s, `\&`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if isEscape(s, i) {
+			i++
+		} else if s[i] == '&' {
+			if text, end := entity(s, i); end > 0 {
+				b.WriteString(text)
+				i = end - 1
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,74 @@
+package tokenizer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Extensions is a set of optional syntax features, combined with `|`.
+type Extensions uint
+
+// NoExtensions only enables the core syntax.
+const NoExtensions Extensions = 0
+
+const (
+	// ExtStrikethrough parses `~~text~~` into a Strikethrough.
+	ExtStrikethrough Extensions = 1 << iota
+	// ExtHighlight parses `==text==` into a Highlight.
+	ExtHighlight
+	// ExtSubscript parses `~text~` into a Subscript.
+	ExtSubscript
+	// ExtSuperscript parses `^text^` into a Superscript.
+	ExtSuperscript
+	// ExtLinkify turns the URLs and email addresses found in text into links.
+	ExtLinkify
+)
+
+// extensionNames maps the names used on the command line to extensions.
+var extensionNames = map[string]Extensions{
+	"strikethrough": ExtStrikethrough,
+	"highlight":     ExtHighlight,
+	"subscript":     ExtSubscript,
+	"superscript":   ExtSuperscript,
+	"linkify":       ExtLinkify,
+}
+
+// Has reports whether every extension of ext is enabled in e.
+func (e Extensions) Has(ext Extensions) bool {
+	return e&ext == ext
+}
+
+// ExtensionNames returns the names accepted by ParseExtensions, sorted.
+func ExtensionNames() []string {
+	names := []string{}
+	for name := range extensionNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseExtensions parses a comma separated list of extension names. The name
+// `all` enables every extension.
+func ParseExtensions(list string) (Extensions, error) {
+	ext := NoExtensions
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if name == "all" {
+			for _, value := range extensionNames {
+				ext |= value
+			}
+			continue
+		}
+		value, found := extensionNames[name]
+		if !found {
+			return NoExtensions, fmt.Errorf("unknown extension %q", name)
+		}
+		ext |= value
+	}
+	return ext, nil
+}
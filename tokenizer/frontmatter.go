@@ -0,0 +1,538 @@
+package tokenizer
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxFrontMatter is the number of lines front matter may span, the lines
+// opening longer front matter being left as Markdown.
+const maxFrontMatter = 1000
+
+// frontMatterClosers returns the lines which may close the YAML or TOML front
+// matter opened by the line first, nil when first doesn't open any.
+func frontMatterClosers(first string) []string {
+	switch strings.TrimRight(first, " \t") {
+	case "---":
+		return []string{"---", "..."}
+	case "+++":
+		return []string{"+++"}
+	}
+	return nil
+}
+
+// closesFrontMatter reports whether line is one of closers.
+func closesFrontMatter(line string, closers []string) bool {
+	line = strings.TrimRight(line, " \t")
+	for _, closer := range closers {
+		if line == closer {
+			return true
+		}
+	}
+	return false
+}
+
+// parseFrontMatter parses the metadata starting a document: YAML between `---`
+// lines (the closing one may be `...`), TOML between `+++` lines, or a JSON
+// object starting with `{`, spanning up to maxFrontMatter lines. It returns
+// the metadata and the number of lines it spans, 0 when the lines don't start
+// with front matter, and whether the lines open front matter which isn't
+// closed yet. Front matter that doesn't parse is left as Markdown.
+func parseFrontMatter(lines []string) (map[string]any, int, bool) {
+	if strings.HasPrefix(lines[0], "{") {
+		if len(lines) > maxFrontMatter {
+			lines = lines[:maxFrontMatter]
+		}
+		meta, end, open := jsonFrontMatter(lines)
+		return meta, end, open && len(lines) < maxFrontMatter
+	}
+
+	closers := frontMatterClosers(lines[0])
+	if closers == nil {
+		return nil, 0, false
+	}
+	parse := parseYAML
+	if closers[0] == "+++" {
+		parse = parseTOML
+	}
+
+	for end := 1; end < len(lines) && end < maxFrontMatter; end++ {
+		if !closesFrontMatter(lines[end], closers) {
+			continue
+		}
+		meta, ok := parse(lines[1:end])
+		if !ok {
+			return nil, 0, false
+		}
+		return meta, end + 1, false
+	}
+	return nil, 0, len(lines) < maxFrontMatter
+}
+
+// frontMatterFormat returns the format of the YAML or TOML front matter the
+// lines start with, "" unless it's closed within maxFrontMatter lines.
+func frontMatterFormat(lines []string) string {
+	closers := frontMatterClosers(lines[0])
+	if closers == nil {
+		return ""
+	}
+	for end := 1; end < len(lines) && end < maxFrontMatter; end++ {
+		if closesFrontMatter(lines[end], closers) {
+			if closers[0] == "+++" {
+				return "TOML"
+			}
+			return "YAML"
+		}
+	}
+	return ""
+}
+
+// frontMatterOpen reports whether the lines open front matter which isn't
+// closed yet, the lines but the last one being known to leave it open.
+func frontMatterOpen(lines []string) bool {
+	if strings.HasPrefix(lines[0], "{") {
+		_, _, open := parseFrontMatter(lines)
+		return open
+	}
+	closers := frontMatterClosers(lines[0])
+	if closers == nil || len(lines) >= maxFrontMatter {
+		return false
+	}
+	return len(lines) == 1 || !closesFrontMatter(lines[len(lines)-1], closers)
+}
+
+// jsonFrontMatter parses the JSON object starting the lines, which must end a
+// line.
+func jsonFrontMatter(lines []string) (map[string]any, int, bool) {
+	content := strings.Join(lines, "\n")
+	decoder := json.NewDecoder(strings.NewReader(content))
+	meta := map[string]any{}
+	if err := decoder.Decode(&meta); err != nil {
+		return nil, 0, err == io.ErrUnexpectedEOF
+	}
+
+	end := int(decoder.InputOffset())
+	rest := content[end:]
+	if newline := strings.IndexByte(rest, '\n'); newline >= 0 {
+		rest = rest[:newline]
+	}
+	if !isEmpty(rest) {
+		return nil, 0, false
+	}
+	return meta, strings.Count(content[:end], "\n") + 1, false
+}
+
+// scalar parses a single value: a quoted string, a boolean, an integer, a
+// float, or an unquoted string when it's none of them.
+func scalar(value string) any {
+	switch {
+	case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+		if s, err := strconv.Unquote(value); err == nil {
+			return s
+		}
+		return value[1 : len(value)-1]
+	case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+	case value == "true":
+		return true
+	case value == "false":
+		return false
+	}
+	if n, err := strconv.Atoi(value); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f
+	}
+	return value
+}
+
+// closingQuote returns the index of the quote closing the string starting
+// value, -1 when it isn't closed. Backslashes escape characters within `"`,
+// a doubled quote standing for one within `'`.
+func closingQuote(value string) int {
+	for i := 1; i < len(value); i++ {
+		switch {
+		case value[0] == '"' && value[i] == '\\':
+			i++
+		case value[i] == value[0]:
+			if value[0] == '\'' && i+1 < len(value) && value[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+// splitList splits the content of a `[a, "b, c"]` list at its commas, except
+// the ones within quotes or nested lists. It returns false when the quotes or
+// brackets aren't balanced.
+func splitList(list string) ([]string, bool) {
+	items := []string{}
+	depth, quote, start := 0, byte(0), 0
+	for i := 0; i < len(list); i++ {
+		c := list[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			items = append(items, strings.TrimSpace(list[start:i]))
+			start = i + 1
+		}
+	}
+	if quote != 0 || depth != 0 {
+		return nil, false
+	}
+	if last := strings.TrimSpace(list[start:]); last != "" || len(items) > 0 {
+		items = append(items, last)
+	}
+	return items, true
+}
+
+// yamlLine is a significant line of YAML, with the width of its indentation.
+type yamlLine struct {
+	indent int
+	text   string
+}
+
+// parseYAML parses the subset of YAML used in front matter: nested mappings,
+// sequences of `- item` lines or `[a, b]`, and scalars, with comments.
+func parseYAML(lines []string) (map[string]any, bool) {
+	significant := []yamlLine{}
+	for _, line := range lines {
+		text := strings.TrimRight(line, " \t")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed[0] == '#' {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, false
+		}
+		significant = append(significant, yamlLine{len(text) - len(trimmed), trimmed})
+	}
+	if len(significant) == 0 {
+		return map[string]any{}, true
+	}
+
+	meta, end, ok := yamlMapping(significant, 0, significant[0].indent)
+	if !ok || end < len(significant) {
+		return nil, false
+	}
+	return meta, true
+}
+
+func isYAMLItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// yamlKey splits `key: value` into its key and value.
+func yamlKey(text string) (string, string, bool) {
+	colon := strings.Index(text, ": ")
+	if colon < 0 {
+		if !strings.HasSuffix(text, ":") {
+			return "", "", false
+		}
+		colon = len(text) - 1
+	}
+	key := strings.TrimSpace(text[:colon])
+	if key == "" {
+		return "", "", false
+	}
+	if k, ok := scalar(key).(string); ok {
+		key = k
+	}
+	return key, strings.TrimSpace(text[colon+1:]), true
+}
+
+// yamlMapping parses the `key: value` lines at indent starting from the line
+// i. It returns the mapping and the index of the line following it.
+func yamlMapping(lines []yamlLine, i int, indent int) (map[string]any, int, bool) {
+	mapping := map[string]any{}
+	for i < len(lines) && lines[i].indent == indent && !isYAMLItem(lines[i].text) {
+		key, value, ok := yamlKey(lines[i].text)
+		if !ok {
+			return nil, 0, false
+		}
+		i++
+
+		if value != "" {
+			if mapping[key], ok = yamlScalar(value); !ok {
+				return nil, 0, false
+			}
+			continue
+		}
+		// The value is the block on the next lines, a sequence possibly
+		// being at the indentation of the key.
+		switch {
+		case i < len(lines) && lines[i].indent > indent:
+			mapping[key], i, ok = yamlBlock(lines, i)
+		case i < len(lines) && lines[i].indent == indent && isYAMLItem(lines[i].text):
+			mapping[key], i, ok = yamlSequence(lines, i, indent)
+		default:
+			mapping[key] = nil
+		}
+		if !ok {
+			return nil, 0, false
+		}
+	}
+	if i < len(lines) && lines[i].indent > indent {
+		return nil, 0, false
+	}
+	return mapping, i, true
+}
+
+// yamlBlock parses the mapping or sequence starting at the line i.
+func yamlBlock(lines []yamlLine, i int) (any, int, bool) {
+	if isYAMLItem(lines[i].text) {
+		return yamlSequence(lines, i, lines[i].indent)
+	}
+	return yamlMapping(lines, i, lines[i].indent)
+}
+
+// yamlSequence parses the `- item` lines at indent starting from the line i.
+// An item may be a mapping, its first key following the dash.
+func yamlSequence(lines []yamlLine, i int, indent int) ([]any, int, bool) {
+	sequence := []any{}
+	for i < len(lines) && lines[i].indent == indent && isYAMLItem(lines[i].text) {
+		text := lines[i].text
+		item := strings.TrimLeft(text[1:], " ")
+		var value any
+		ok := true
+		switch {
+		case item == "":
+			value = nil
+			if i+1 < len(lines) && lines[i+1].indent > indent {
+				value, i, ok = yamlBlock(lines, i+1)
+			} else {
+				i++
+			}
+		case isYAMLItem(item) || strings.HasSuffix(item, ":") || strings.Contains(item, ": ") && !strings.HasPrefix(item, "\"") && !strings.HasPrefix(item, "'"):
+			// Parse the item as if it started a line of its own.
+			nested := append([]yamlLine{}, lines...)
+			nested[i] = yamlLine{indent + len(text) - len(item), item}
+			value, i, ok = yamlBlock(nested, i)
+		default:
+			value, ok = yamlScalar(item)
+			i++
+		}
+		if !ok {
+			return nil, 0, false
+		}
+		sequence = append(sequence, value)
+	}
+	return sequence, i, true
+}
+
+// yamlScalar parses a value written on the line of its key: a scalar, or a
+// `[a, b]` sequence. A comment may follow it.
+func yamlScalar(value string) (any, bool) {
+	if value[0] == '"' || value[0] == '\'' {
+		end := closingQuote(value)
+		if end < 0 {
+			return nil, false
+		}
+		if rest := strings.TrimSpace(value[end+1:]); rest != "" && rest[0] != '#' {
+			return nil, false
+		}
+		value = value[:end+1]
+	} else if comment := strings.Index(value, " #"); comment >= 0 {
+		value = strings.TrimSpace(value[:comment])
+	}
+	switch value {
+	case "null", "~":
+		return nil, true
+	case "|", ">":
+		// Block scalars aren't supported.
+		return nil, false
+	}
+	if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+		items, ok := splitList(value[1 : len(value)-1])
+		if !ok {
+			return nil, false
+		}
+		sequence := []any{}
+		for _, item := range items {
+			if item == "" {
+				return nil, false
+			}
+			v, ok := yamlScalar(item)
+			if !ok {
+				return nil, false
+			}
+			sequence = append(sequence, v)
+		}
+		return sequence, true
+	}
+	return scalar(value), true
+}
+
+// parseTOML parses the subset of TOML used in front matter: `key = value`
+// pairs, dotted keys, `[table]` and `[[array]]` headers, and values being
+// strings, numbers, booleans, dates (kept as strings) and arrays, with
+// comments.
+func parseTOML(lines []string) (map[string]any, bool) {
+	root := map[string]any{}
+	table := root
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		var ok bool
+		switch {
+		case strings.HasPrefix(line, "[["):
+			if !strings.HasSuffix(line, "]]") {
+				return nil, false
+			}
+			keys := tomlKeys(line[2 : len(line)-2])
+			parent, ok := tomlTable(root, keys[:len(keys)-1])
+			if !ok {
+				return nil, false
+			}
+			last := keys[len(keys)-1]
+			array, isArray := parent[last].([]any)
+			if _, found := parent[last]; found && !isArray {
+				return nil, false
+			}
+			table = map[string]any{}
+			parent[last] = append(array, table)
+		case strings.HasPrefix(line, "["):
+			if !strings.HasSuffix(line, "]") {
+				return nil, false
+			}
+			if table, ok = tomlTable(root, tomlKeys(line[1:len(line)-1])); !ok {
+				return nil, false
+			}
+		default:
+			equal := strings.IndexByte(line, '=')
+			if equal < 0 {
+				return nil, false
+			}
+			keys := tomlKeys(line[:equal])
+			parent, ok := tomlTable(table, keys[:len(keys)-1])
+			if !ok {
+				return nil, false
+			}
+			value, ok := tomlValue(strings.TrimSpace(line[equal+1:]))
+			if !ok {
+				return nil, false
+			}
+			parent[keys[len(keys)-1]] = value
+		}
+	}
+	return root, true
+}
+
+// tomlKeys splits a dotted key into its parts.
+func tomlKeys(key string) []string {
+	keys := strings.Split(key, ".")
+	for i, k := range keys {
+		keys[i] = strings.TrimSpace(k)
+		if s, ok := scalar(keys[i]).(string); ok {
+			keys[i] = s
+		}
+	}
+	return keys
+}
+
+// tomlTable returns the table at the path of keys from table, creating the
+// missing ones. The last element of an array of tables stands for the array,
+// which can't be empty.
+func tomlTable(table map[string]any, keys []string) (map[string]any, bool) {
+	for _, key := range keys {
+		if key == "" {
+			return nil, false
+		}
+		switch value := table[key].(type) {
+		case nil:
+			next := map[string]any{}
+			table[key] = next
+			table = next
+		case map[string]any:
+			table = value
+		case []any:
+			if len(value) == 0 {
+				return nil, false
+			}
+			last, ok := value[len(value)-1].(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			table = last
+		default:
+			return nil, false
+		}
+	}
+	return table, true
+}
+
+// tomlValue parses the value of a key, followed by an optional comment.
+func tomlValue(value string) (any, bool) {
+	if value == "" {
+		return nil, false
+	}
+	if value[0] == '[' {
+		end := strings.LastIndexByte(value, ']')
+		if end < 0 {
+			return nil, false
+		}
+		items, ok := splitList(value[1:end])
+		if !ok {
+			return nil, false
+		}
+		array := []any{}
+		for i, item := range items {
+			if item == "" && i == len(items)-1 && i > 0 {
+				break // trailing comma
+			}
+			v, ok := tomlValue(item)
+			if !ok {
+				return nil, false
+			}
+			array = append(array, v)
+		}
+		return array, true
+	}
+
+	if value[0] == '"' || value[0] == '\'' {
+		end := closingQuote(value)
+		if end < 0 {
+			return nil, false
+		}
+		if rest := strings.TrimSpace(value[end+1:]); rest != "" && rest[0] != '#' {
+			return nil, false
+		}
+		if value[0] == '\'' {
+			return value[1:end], true
+		}
+		return scalar(value[:end+1]), true
+	}
+
+	if comment := strings.IndexByte(value, '#'); comment >= 0 {
+		value = strings.TrimSpace(value[:comment])
+	}
+	v := scalar(strings.ReplaceAll(value, "_", ""))
+	if _, isString := v.(string); isString {
+		// Dates and times are the only unquoted strings.
+		if len(value) < 10 || value[4] != '-' || value[7] != '-' {
+			return nil, false
+		}
+		return value, true
+	}
+	return v, true
+}
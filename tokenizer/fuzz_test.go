@@ -0,0 +1,112 @@
+package tokenizer
+
+import (
+	"strings"
+	"testing"
+)
+
+// checkPositions fails when a token position is out of the source or ends
+// before it starts.
+func checkPositions(t *testing.T, content string, tokens []*Token) {
+	for _, token := range tokens {
+		start, end := token.Pos.Start, token.Pos.End
+		if start.Offset < 0 || end.Offset > len(content) || start.Offset > end.Offset {
+			t.Errorf("%s has an invalid position %+v in %q", token.Ttype, token.Pos, content)
+		}
+		checkPositions(t, content, token.Children)
+	}
+}
+
+func FuzzTokenize(f *testing.F) {
+	seeds := []string{
+		"# Hello world",
+		"###",
+		"   ",
+		">",
+		"> quote\n>",
+		"-",
+		"- item\n -",
+		"1.",
+		"1. item\n  text",
+		"[unclosed",
+		"[text](unclosed",
+		"![alt",
+		"```go\ncode",
+		"- a\n  ```go\n  b",
+		"\\*a\\* \\\\&amp;&#x41;&#; [\\]](<a\\>b> \"\\\"\")\\",
+		"<div>\n<pre>\n\n<!--\n-->\na <b c='d\ne'>*f*</b> <!x> <?y?> <![CDATA[z]]>",
+		"````{.go #a k=\"v w\" .c}\n~~~\n```\n````\n\t\tindented\n\n    code",
+		"*a **b*** _c_ [d](e) ![f](g)",
+		"a | b\n-|:-:\n| c \\| d",
+		"- [x] task\n- [ ] todo",
+		"~~a~~ ==b== c~d~ e^f^",
+		"`` a ` b `` [`]`](c)",
+		"<https://a.b> <a@b.co> www.x.com/(y)., a@b.co.",
+		"[a][b] [c][] ![d]\n\n> [B]: <u v> \"t\"\n[c]: /c (t)",
+		"a[^1] b[^x]\n\n[^1]: note[^x]\n\n    more\n[^X]:\n    > [^1]",
+		"---\na: [1, \"b\"]\nc:\n- d: e\n  f: 'g'\n---\n+++\nx = 1\n[y.z]\nw = [\"v\"]\n+++",
+		"{\"a\": {\"b\": [1]}}\ntext",
+		"Title\n===\n- - -\n> a\n---\n- b\n  c\n  ___",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, content string) {
+		tokens := NewParser(content).Tokenize()
+		checkPositions(t, content, tokens)
+		ext, _ := ParseExtensions("all")
+		checkPositions(t, content, NewParser(content).WithExtensions(ext).Tokenize())
+
+		_, diagnostics := NewParser(content).TokenizeWithDiagnostics()
+		for _, diagnostic := range diagnostics {
+			if strings.HasPrefix(diagnostic.Message, "internal error") {
+				t.Errorf("%q: %s", content, diagnostic)
+			}
+		}
+	})
+}
+
+func TestDiagnostics(t *testing.T) {
+	cases := map[string]string{
+		"```go\ncode":           "1:1: code block is not closed",
+		"see [text](unclosed":   "1:11: link destination is not closed",
+		"\n![alt without close": "2:1: image description is not closed",
+	}
+
+	for content, expected := range cases {
+		_, diagnostics := NewParser(content).TokenizeWithDiagnostics()
+		if len(diagnostics) != 1 || diagnostics[0].String() != expected {
+			t.Errorf("%q: expected `%s`, got %v", content, expected, diagnostics)
+		}
+	}
+}
+
+func TestNoDiagnostics(t *testing.T) {
+	tokens, diagnostics := NewParser("# Title\n\n- [a](b)\n\n```go\ncode\n```").TokenizeWithDiagnostics()
+	if len(tokens) != 3 {
+		t.Errorf("Expected 3 tokens, got %d", len(tokens))
+	}
+	if len(diagnostics) != 0 {
+		t.Errorf("Unexpected diagnostics %v", diagnostics)
+	}
+}
+
+func TestRecoverFromParserPanic(t *testing.T) {
+	p := NewParser("first\n\nsecond")
+	failing := func(p *parser, index int) ([]*Token, int) {
+		if index == 2 {
+			panic("boom")
+		}
+		return nil, 0
+	}
+	p.parsers = append([]ParserFunc{failing}, p.parsers...)
+
+	tokens, diagnostics := p.TokenizeWithDiagnostics()
+	if len(tokens) != 2 || tokens[1].Ttype != Paragraph {
+		t.Errorf("Block should be parsed by the next parser %s", tree(tokens))
+	}
+	if len(diagnostics) != 1 || diagnostics[0].String() != "3:1: internal error, block skipped: boom" {
+		t.Errorf("Panic not reported %v", diagnostics)
+	}
+}
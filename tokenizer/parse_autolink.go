@@ -0,0 +1,254 @@
+package tokenizer
+
+import "strings"
+
+func isAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// isURI reports whether text is an absolute URI: a scheme of 2 to 32
+// characters, a colon and no spaces, `<` or `>`.
+func isURI(text string) bool {
+	colon := strings.IndexByte(text, ':')
+	if colon < 2 || colon > 32 || !isAlnum(text[0]) || text[0] <= '9' {
+		return false
+	}
+	for i := 1; i < colon; i++ {
+		if !isAlnum(text[i]) && text[i] != '+' && text[i] != '.' && text[i] != '-' {
+			return false
+		}
+	}
+	for i := colon + 1; i < len(text); i++ {
+		if text[i] <= ' ' || text[i] == '<' || text[i] == '>' {
+			return false
+		}
+	}
+	return true
+}
+
+// isEmail reports whether text is an email address.
+func isEmail(text string) bool {
+	at := strings.IndexByte(text, '@')
+	if at < 1 {
+		return false
+	}
+	for i := 0; i < at; i++ {
+		if !isAlnum(text[i]) && !strings.ContainsRune(".!#$%&'*+/=?^_`{|}~-", rune(text[i])) {
+			return false
+		}
+	}
+
+	for _, label := range strings.Split(text[at+1:], ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			if !isAlnum(label[i]) && label[i] != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// parseAutolink parses `<https://example.com>` or `<user@example.com>`
+// starting at the `<` at index i into a Link with the `autolink` attribute.
+func parseAutolink(line string, i int, m sourceMap) (*Token, int) {
+	end := strings.IndexByte(line[i+1:], '>')
+	if end < 0 {
+		return nil, 0
+	}
+	end += i + 1
+
+	address := line[i+1 : end]
+	url := address
+	if !isURI(address) {
+		if !isEmail(address) {
+			return nil, 0
+		}
+		url = "mailto:" + address
+	}
+
+	text := newToken(Text, address)
+	text.Pos = Position{m.at(i + 1), m.at(end)}
+	token := newToken(Link, address)
+	token.Attrs["url"] = url
+	token.Attrs["autolink"] = true
+	token.Pos = Position{m.at(i), m.at(end + 1)}
+	token.Children = []*Token{text}
+	return token, end + 1
+}
+
+// linkify turns the URLs and email addresses found in the text of tokens
+// into links, except within links and images.
+func linkify(tokens []*Token) []*Token {
+	result := []*Token{}
+	for _, token := range tokens {
+		switch token.Ttype {
+		case Text:
+			result = append(result, linkifyText(token)...)
+		case Link, Image, CodeSpan:
+			result = append(result, token)
+		default:
+			token.Children = linkify(token.Children)
+			result = append(result, token)
+		}
+	}
+	return result
+}
+
+// linkifyText splits a text token around the `www.`, `http://` and
+// `https://` URLs and the email addresses it contains.
+func linkifyText(token *Token) []*Token {
+	text := token.Value
+	tokens := []*Token{}
+	piece := func(ttype TokenType, from int, to int) *Token {
+		t := newToken(ttype, text[from:to])
+		t.Pos = Position{token.at(from), token.at(to)}
+		tokens = append(tokens, t)
+		return t
+	}
+
+	textStart := 0
+	for i := 0; i < len(text); i++ {
+		start, end, url := i, 0, ""
+		if i == 0 || isSpace(text[i-1]) || strings.IndexByte("*_~(", text[i-1]) >= 0 {
+			end, url = extendedURL(text, i)
+		}
+		if end == 0 && text[i] == '@' {
+			start, end = extendedEmail(text, textStart, i)
+			url = "mailto:" + text[start:end]
+		}
+		if end == 0 {
+			continue
+		}
+
+		if start > textStart {
+			piece(Text, textStart, start)
+		}
+		link := piece(Link, start, end)
+		link.Attrs["url"] = url
+		link.Children = []*Token{newToken(Text, link.Value)}
+		link.Children[0].Pos = link.Pos
+		textStart, i = end, end-1
+	}
+
+	if textStart == 0 {
+		return []*Token{token}
+	}
+	if textStart < len(text) {
+		piece(Text, textStart, len(text))
+	}
+	return tokens
+}
+
+// extendedURL returns the end of the URL starting at index i of text, with
+// its trailing punctuation left out, and its destination. The end is 0 when
+// there's no URL.
+func extendedURL(text string, i int) (int, string) {
+	host := i
+	switch {
+	case strings.HasPrefix(text[i:], "www."):
+	case strings.HasPrefix(text[i:], "http://"):
+		host += len("http://")
+	case strings.HasPrefix(text[i:], "https://"):
+		host += len("https://")
+	default:
+		return 0, ""
+	}
+
+	end := host
+	for end < len(text) && !isSpace(text[end]) && text[end] != '<' {
+		end++
+	}
+	end = trimURL(text, i, end)
+
+	domain := host
+	for domain < end && (isAlnum(text[domain]) || strings.IndexByte("._-", text[domain]) >= 0) {
+		domain++
+	}
+	if !isDomain(text[host:domain]) {
+		return 0, ""
+	}
+
+	if host == i {
+		return end, "http://" + text[i:end]
+	}
+	return end, text[i:end]
+}
+
+// trimURL returns the end of the URL text[start:end] without its trailing
+// punctuation, unbalanced closing parentheses and entity references.
+func trimURL(text string, start int, end int) int {
+	for end > start {
+		last := text[end-1]
+		switch {
+		case strings.IndexByte("?!.,:*_~'\"", last) >= 0:
+			end--
+		case last == ')' && strings.Count(text[start:end], ")") > strings.Count(text[start:end], "("):
+			end--
+		case last == ';':
+			amp := end - 2
+			for amp > start && isAlnum(text[amp]) {
+				amp--
+			}
+			if amp == end-2 || text[amp] != '&' {
+				return end
+			}
+			end = amp
+		default:
+			return end
+		}
+	}
+	return end
+}
+
+// isDomain reports whether domain is made of segments of letters, digits, `-`
+// and `_` separated by periods, at least two of them, the last two without
+// underscores.
+func isDomain(domain string) bool {
+	segments := strings.Split(domain, ".")
+	if len(segments) < 2 {
+		return false
+	}
+	for i, segment := range segments {
+		if segment == "" {
+			return false
+		}
+		if i >= len(segments)-2 && strings.Contains(segment, "_") {
+			return false
+		}
+	}
+	return true
+}
+
+// extendedEmail returns the bounds of the email address around the `@` at
+// index at of text, found after index from. The end is 0 when there's no
+// address.
+func extendedEmail(text string, from int, at int) (int, int) {
+	start := at
+	for start > from && (isAlnum(text[start-1]) || strings.IndexByte(".-_+", text[start-1]) >= 0) {
+		start--
+	}
+	if start == at {
+		return 0, 0
+	}
+
+	end := at + 1
+	for end < len(text) && (isAlnum(text[end]) || strings.IndexByte(".-_", text[end]) >= 0) {
+		end++
+	}
+	for end > at+1 && text[end-1] == '.' {
+		end--
+	}
+	domain := text[at+1 : end]
+	if !strings.Contains(domain, ".") || strings.HasSuffix(domain, "-") || strings.HasSuffix(domain, "_") {
+		return 0, 0
+	}
+	for _, segment := range strings.Split(domain, ".") {
+		if segment == "" {
+			return 0, 0
+		}
+	}
+	return start, end
+}
@@ -1,30 +1,61 @@
 package tokenizer
 
+import "strings"
+
 const (
 	Blockquote TokenType = "Blockquote"
 )
 
-func parseBlockquote(lines []string, index int) ([]*Token, int) {
-	if isEmpty(lines[index]) {
-		return nil, 0
+// quoteMarker returns the width of the `>` marker starting a quoted line,
+// with its indentation and the space following it, or 0.
+func quoteMarker(line string) int {
+	spaces := countSpaces(line)
+	if spaces > 3 || !strings.HasPrefix(line[spaces:], ">") {
+		return 0
+	}
+	if strings.HasPrefix(line[spaces+1:], " ") {
+		return spaces + 2
 	}
+	return spaces + 1
+}
 
-	blockLines := []*Token{}
+func isQuote(line string) bool {
+	return quoteMarker(line) > 0
+}
 
-	for index < len(lines) {
-		if isEmpty(lines[index]) {
+// parseBlockquote parses consecutive quoted lines, stripped of their marker,
+// into the children of a Blockquote. A line without marker continues the quote
+// when it continues a paragraph of the quoted content.
+func parseBlockquote(p *parser, index int) ([]*Token, int) {
+	if !isQuote(p.lines[index]) {
+		return nil, 0
+	}
+
+	lines := []string{}
+	origins := []Point{}
+	lazy := []bool{}
+	content := lazyContent{}
+	end := index
+	for end < len(p.lines) {
+		line := p.lines[end]
+		if isEmpty(line) {
 			break
 		}
 
-		spaces := countSpaces(lines[index])
-
-		if lines[index][spaces:spaces+2] != "> " {
+		marker := quoteMarker(line)
+		if marker == 0 && !content.continues(line) {
 			break
 		}
 
-		blockLines = append(blockLines, newToken(Blockquote, lines[index][spaces+2:]))
-		index++
+		lines = append(lines, line[marker:])
+		origins = append(origins, p.point(end, marker))
+		lazy = append(lazy, marker == 0 || p.isLazy(end))
+		content.add(line[marker:])
+		end++
 	}
 
-	return blockLines, len(blockLines)
+	quote := newToken(Blockquote, "")
+	quote.Pos = p.span(index, countSpaces(p.lines[index]), end-1)
+	quote.Children = p.parseNested(lines, origins, lazy)
+	return []*Token{quote}, end - index
 }
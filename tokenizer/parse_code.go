@@ -6,31 +6,202 @@ const (
 	CodeBloc TokenType = "CodeBloc"
 )
 
-func parseCodeBlock(lines []string, index int) ([]*Token, int) {
-	return parseCodeBlockWithSpaces(lines, index, 0)
+// fence returns the run of at least 3 backticks or tildes opening or closing
+// a code block on line, indented by up to 3 spaces, and the index following
+// it. It returns "" when line has no fence. The info string following a
+// backtick fence can't hold backticks.
+func fence(line string) (string, int) {
+	start := countSpaces(line)
+	if start > 3 || start == len(line) || (line[start] != '`' && line[start] != '~') {
+		return "", 0
+	}
+	end := start
+	for end < len(line) && line[end] == line[start] {
+		end++
+	}
+	if end-start < 3 || (line[start] == '`' && strings.Contains(line[end:], "`")) {
+		return "", 0
+	}
+	return line[start:end], end
 }
 
-func parseCodeBlockWithSpaces(lines []string, index int, spaces int) ([]*Token, int) {
-	if len(lines[index]) < 4+spaces {
-		return nil, 0
-	}
-	if lines[index][spaces:spaces+3] != "```" {
+// isFence reports whether line opens a code block.
+func isFence(line string) bool {
+	marker, _ := fence(line)
+	return marker != ""
+}
+
+// closesFence reports whether line closes the code block opened by marker: a
+// run of the same character, at least as long, followed by nothing but spaces.
+func closesFence(line string, marker string) bool {
+	closing, end := fence(line)
+	return closing != "" && closing[0] == marker[0] && len(closing) >= len(marker) && isEmpty(line[end:])
+}
+
+// parseCodeBlock parses a fenced code block. The lines are kept as they are,
+// but for the indentation of the opening fence. A block that isn't closed runs
+// to the end of the document. The info string following the fence sets the
+// `language` and the `attributes` of the block.
+func parseCodeBlock(p *parser, index int) ([]*Token, int) {
+	lines := p.lines
+	marker, col := fence(lines[index])
+	if marker == "" {
 		return nil, 0
 	}
+	indent := countSpaces(lines[index])
 
-	language := strings.TrimSpace(lines[index][spaces+3:])
 	blocLines := []string{}
 	i := index + 1
-	for i < len(lines) {
-		if len(lines[i]) >= 3 && lines[i][spaces:spaces+3] == "```" {
-			break
+	for i < len(lines) && !closesFence(lines[i], marker) {
+		spaces := countSpaces(lines[i])
+		if spaces > indent {
+			spaces = indent
 		}
-		blocLines = append(blocLines, strings.TrimSpace(lines[i]))
+		blocLines = append(blocLines, lines[i][spaces:])
 		i++
 	}
 
+	skip := len(blocLines) + 2
+	if i == len(lines) {
+		p.warn(p.point(index, indent), "code block is not closed")
+		skip--
+	}
+
 	token := newToken(CodeBloc, strings.Join(blocLines, "\n"))
+	token.Pos = p.span(index, indent, index+skip-1)
+
+	info := strings.TrimSpace(lines[index][col:])
+	language, attributes := infoString(info)
+	token.Attrs["language"] = unescape(language)
+	if info != "" {
+		token.Attrs["info"] = info
+	}
+	if len(attributes) > 0 {
+		token.Attrs["attributes"] = attributes
+	}
+	return []*Token{token}, skip
+}
+
+// codeIndent returns the length of the indentation of at least 4 columns
+// starting an indented code line, a tab counting as 4 columns. It returns 0
+// when line isn't indented enough.
+func codeIndent(line string) int {
+	spaces := countSpaces(line)
+	switch {
+	case spaces >= 4:
+		return 4
+	case spaces < len(line) && line[spaces] == '\t':
+		return spaces + 1
+	}
+	return 0
+}
+
+// parseIndentedCode parses the lines indented by at least 4 columns, and the
+// blank lines between them, into a code block without their first 4 columns.
+// It can't interrupt a paragraph, the indented lines then continuing it.
+func parseIndentedCode(p *parser, index int) ([]*Token, int) {
+	if isEmpty(p.lines[index]) || codeIndent(p.lines[index]) == 0 {
+		return nil, 0
+	}
 
-	token.Attrs["language"] = language
-	return []*Token{token}, len(blocLines) + 2
+	blocLines := []string{}
+	end := index
+	for i := index; i < len(p.lines); i++ {
+		line := p.lines[i]
+		if isEmpty(line) {
+			blocLines = append(blocLines, strings.TrimPrefix(line, "    "))
+			continue
+		}
+		indent := codeIndent(line)
+		if indent == 0 {
+			break
+		}
+		blocLines = append(blocLines, line[indent:])
+		end = i + 1
+	}
+
+	token := newToken(CodeBloc, strings.Join(blocLines[:end-index], "\n"))
+	token.Pos = p.span(index, 0, end-1)
+	token.Attrs["language"] = ""
+	return []*Token{token}, end - index
+}
+
+// infoString parses the info string of a fenced code block: a language
+// followed by anything, or attributes in braces, `{.go linenos=true}`, the
+// first class giving the language. Attributes may also follow the language,
+// `go {linenos=true}`. The attributes hold the `id` set by `#id`, the other
+// classes in `class` and the `key=value` pairs, values being quoted when
+// holding spaces.
+func infoString(info string) (string, map[string]string) {
+	language := ""
+	if !strings.HasPrefix(info, "{") {
+		fields := strings.Fields(info)
+		if len(fields) == 0 {
+			return "", nil
+		}
+		language = fields[0]
+		info = strings.TrimSpace(strings.TrimPrefix(info, language))
+	}
+	if !strings.HasPrefix(info, "{") || !strings.HasSuffix(info, "}") {
+		if language == "" {
+			language = strings.Fields(info)[0]
+		}
+		return language, nil
+	}
+
+	attributes := map[string]string{}
+	classes := []string{}
+	for _, attribute := range splitAttributes(info[1 : len(info)-1]) {
+		switch {
+		case strings.HasPrefix(attribute, "."):
+			if language == "" {
+				language = attribute[1:]
+			} else {
+				classes = append(classes, attribute[1:])
+			}
+		case strings.HasPrefix(attribute, "#"):
+			attributes["id"] = attribute[1:]
+		default:
+			key, value, _ := strings.Cut(attribute, "=")
+			if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+				value = value[1 : len(value)-1]
+			}
+			attributes[key] = value
+		}
+	}
+	if len(classes) > 0 {
+		attributes["class"] = strings.Join(classes, " ")
+	}
+	return language, attributes
+}
+
+// splitAttributes splits s on spaces, except the ones in quotes.
+func splitAttributes(s string) []string {
+	attributes := []string{}
+	start := -1
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+			continue
+		case isSpace(s[i]):
+			if start >= 0 {
+				attributes = append(attributes, s[start:i])
+				start = -1
+			}
+			continue
+		case s[i] == '"' || s[i] == '\'':
+			quote = s[i]
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		attributes = append(attributes, s[start:])
+	}
+	return attributes
 }
@@ -0,0 +1,146 @@
+package tokenizer
+
+import "strings"
+
+const (
+	FootnoteRef        TokenType = "FootnoteRef"
+	FootnoteDefinition TokenType = "FootnoteDefinition"
+	// Footnotes ends the document with the definitions of the footnotes
+	// referred to, in the order of their numbers.
+	Footnotes TokenType = "Footnotes"
+)
+
+// footnoteLabel parses `[^label]` starting at the `[` at index i. It returns
+// the label and the index following the brackets, 0 when there's no label.
+func footnoteLabel(line string, i int) (string, int) {
+	if !strings.HasPrefix(line[i:], "[^") {
+		return "", 0
+	}
+	end := i + 2
+	for end < len(line) && line[end] != ']' {
+		if isSpace(line[end]) || line[end] == '[' {
+			return "", 0
+		}
+		end++
+	}
+	if end == len(line) || end == i+2 {
+		return "", 0
+	}
+	return line[i+2 : end], end + 1
+}
+
+// footnoteDefinition returns the label of the footnote defined by line,
+// `[^label]: note`, and the index following the colon, 0 when line doesn't
+// start a definition.
+func footnoteDefinition(line string) (string, int) {
+	start := countSpaces(line)
+	if start > 3 || start == len(line) {
+		return "", 0
+	}
+	label, col := footnoteLabel(line, start)
+	if col == 0 || col == len(line) || line[col] != ':' {
+		return "", 0
+	}
+	return label, col + 1
+}
+
+func isFootnoteDefinition(line string) bool {
+	_, col := footnoteDefinition(line)
+	return col > 0
+}
+
+// parseFootnote parses the definition of a footnote, `[^label]: note`, the
+// lines indented by 4 columns after it being part of the note. The definition
+// gives no token where it is: it is recorded in the document, the first one
+// of a label being used, and ends the document if the footnote is referred to.
+func parseFootnote(p *parser, index int) ([]*Token, int) {
+	line := p.lines[index]
+	label, col := footnoteDefinition(line)
+	if col == 0 {
+		return nil, 0
+	}
+	start := countSpaces(line)
+	for col < len(line) && isBlank(line[col]) {
+		col++
+	}
+
+	lines, origins, lazy, end := indentedContent(p, index, col, 4)
+	definition := newToken(FootnoteDefinition, label)
+	definition.Pos = p.span(index, start, end-1)
+	definition.Children = p.parseNested(lines, origins, lazy)
+
+	// The same definition is parsed again when streaming.
+	key := normalizeLabel(label)
+	if previous, found := p.doc.footnotes[key]; !found || previous.Pos.Start == definition.Pos.Start {
+		p.doc.footnotes[key] = definition
+	}
+	return []*Token{}, end - index
+}
+
+// parseFootnoteRef parses `[^label]` starting at the `[` at index i, when the
+// footnote is defined. It returns the reference and the index following it.
+func parseFootnoteRef(p *parser, line string, i int, m sourceMap) (*Token, int) {
+	label, end := footnoteLabel(line, i)
+	if end == 0 {
+		return nil, 0
+	}
+	if _, found := p.doc.footnotes[normalizeLabel(label)]; !found {
+		return nil, 0
+	}
+
+	token := newToken(FootnoteRef, label)
+	token.Pos = Position{m.at(i), m.at(end)}
+	return token, end
+}
+
+// numberFootnotes numbers the footnotes in the order they are first referred
+// to in tokens. A reference gets the `number` of its footnote and its rank
+// among the references to it as `reference`.
+func (d *document) numberFootnotes(tokens []*Token) {
+	for _, token := range tokens {
+		if token.Ttype != FootnoteRef {
+			d.numberFootnotes(token.Children)
+			continue
+		}
+		key := normalizeLabel(token.Value)
+		if d.footnoteRefs[key] == 0 {
+			d.footnoteOrder = append(d.footnoteOrder, key)
+		}
+		d.footnoteRefs[key]++
+		token.Attrs["number"] = d.footnoteNumber(key)
+		token.Attrs["reference"] = d.footnoteRefs[key]
+	}
+}
+
+// footnoteNumber returns the number of the footnote with the given key.
+func (d *document) footnoteNumber(key string) int {
+	for i, k := range d.footnoteOrder {
+		if k == key {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// footnoteSection returns the Footnotes block holding the definitions of the
+// footnotes referred to, nil when there's none. The references found in the
+// definitions number the footnotes coming after them. Definitions have their
+// `number` and the count of their `references` for links back to them. The
+// block is located at end, the end of the document.
+func (d *document) footnoteSection(end Point) *Token {
+	if len(d.footnoteOrder) == 0 {
+		return nil
+	}
+
+	section := newToken(Footnotes, "")
+	section.Pos = Position{end, end}
+	for i := 0; i < len(d.footnoteOrder); i++ {
+		key := d.footnoteOrder[i]
+		definition := d.footnotes[key]
+		d.numberFootnotes(definition.Children)
+		definition.Attrs["number"] = i + 1
+		definition.Attrs["references"] = d.footnoteRefs[key]
+		section.Children = append(section.Children, definition)
+	}
+	return section
+}
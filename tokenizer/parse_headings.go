@@ -9,33 +9,55 @@ const (
 	Heading6           = "Heading6"
 )
 
-func parseHeading(lines []string, index int) ([]*Token, int) {
-	line := lines[index]
-	if isEmpty(line) {
-		return nil, 0
+var headings = map[int]TokenType{
+	1: Heading1,
+	2: Heading2,
+	3: Heading3,
+	4: Heading4,
+	5: Heading5,
+	6: Heading6,
+}
+
+// HeadingLevel returns the level of a heading token, 0 for other tokens.
+func HeadingLevel(t *Token) int {
+	for level, ttype := range headings {
+		if t.Ttype == ttype {
+			return level
+		}
 	}
+	return 0
+}
 
+// headingLevel returns the number of `#` starting a heading line, 0 when the
+// line isn't a heading.
+func headingLevel(line string) int {
 	i := 0
-	for line[i] == '#' {
+	for i < len(line) && line[i] == '#' {
 		i++
 	}
 
-	if line[i] != ' ' {
-		return nil, 0
+	if i == len(line) || line[i] != ' ' {
+		return 0
 	}
+	if _, found := headings[i]; !found {
+		return 0
+	}
+	return i
+}
 
-	headings := map[int]TokenType{
-		1: Heading1,
-		2: Heading2,
-		3: Heading3,
-		4: Heading4,
-		5: Heading5,
-		6: Heading6,
+func parseHeading(p *parser, index int) ([]*Token, int) {
+	line := p.lines[index]
+	if isEmpty(line) {
+		return nil, 0
 	}
 
-	if value, found := headings[i]; found {
-		return []*Token{newToken(value, line[i+1:])}, 1
+	level := headingLevel(line)
+	if level == 0 {
+		return nil, 0
 	}
 
-	return nil, 0
+	heading := newToken(headings[level], line[level+1:])
+	heading.Pos = p.span(index, 0, index)
+	p.inline(heading, heading.Value, sourceMap{{0, p.point(index, level+1)}})
+	return []*Token{heading}, 1
 }
@@ -4,12 +4,39 @@ const (
 	Hr TokenType = "Hr"
 )
 
-func parseHr(lines []string, index int) ([]*Token, int) {
-	if isEmpty(lines[index]) {
+// isHr reports whether line is a thematic break: at least three `*`, `-` or
+// `_` of the same kind, possibly separated by spaces or tabs, indented by up
+// to 3 spaces.
+func isHr(line string) bool {
+	spaces := countSpaces(line)
+	if spaces > 3 {
+		return false
+	}
+
+	var marker byte
+	count := 0
+	for i := spaces; i < len(line); i++ {
+		switch c := line[i]; {
+		case isBlank(c):
+		case (c == '*' || c == '-' || c == '_') && (marker == 0 || c == marker):
+			marker = c
+			count++
+		default:
+			return false
+		}
+	}
+	return count >= 3
+}
+
+func parseHr(p *parser, index int) ([]*Token, int) {
+	line := p.lines[index]
+	if isEmpty(line) {
 		return nil, 0
 	}
-	if lines[index] == "---" || lines[index] == "===" {
-		return []*Token{newToken(Hr, "")}, 1
+	if isHr(line) {
+		hr := newToken(Hr, "")
+		hr.Pos = p.span(index, countSpaces(line), index)
+		return []*Token{hr}, 1
 	}
 	return nil, 0
 }
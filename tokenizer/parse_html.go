@@ -0,0 +1,273 @@
+package tokenizer
+
+import "strings"
+
+const (
+	HTMLBlock  TokenType = "HTMLBlock"
+	HTMLInline TokenType = "HTMLInline"
+)
+
+// rawTags are the elements whose HTML block only ends with their closing tag,
+// blank lines included.
+var rawTags = []string{"pre", "script", "style", "textarea"}
+
+// blockTags are the elements starting an HTML block ending at a blank line.
+var blockTags = map[string]bool{
+	"address": true, "article": true, "aside": true, "base": true, "basefont": true,
+	"blockquote": true, "body": true, "caption": true, "center": true, "col": true,
+	"colgroup": true, "dd": true, "details": true, "dialog": true, "dir": true,
+	"div": true, "dl": true, "dt": true, "fieldset": true, "figcaption": true,
+	"figure": true, "footer": true, "form": true, "frame": true, "frameset": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"head": true, "header": true, "hr": true, "html": true, "iframe": true,
+	"legend": true, "li": true, "link": true, "main": true, "menu": true,
+	"menuitem": true, "nav": true, "noframes": true, "ol": true, "optgroup": true,
+	"option": true, "p": true, "param": true, "search": true, "section": true,
+	"summary": true, "table": true, "tbody": true, "td": true, "tfoot": true,
+	"th": true, "thead": true, "title": true, "tr": true, "track": true, "ul": true,
+}
+
+// htmlEnds maps the kinds of HTML blocks ending with a given text to that
+// text, the first kind ending with the closing tag of a raw element.
+var htmlEnds = map[int]string{2: "-->", 3: "?>", 4: ">", 5: "]]>"}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// tagName returns the end of the tag name starting at index i, i when there's
+// no name: a letter followed by letters, digits and hyphens.
+func tagName(s string, i int) int {
+	if i >= len(s) || !isLetter(s[i]) {
+		return i
+	}
+	end := i + 1
+	for end < len(s) && (isAlnum(s[end]) || s[end] == '-') {
+		end++
+	}
+	return end
+}
+
+// htmlBlockKind returns which of the CommonMark start conditions line meets,
+// from 1 to 7, 0 when it doesn't start an HTML block:
+//
+//  1. `<pre`, `<script`, `<style` or `<textarea`, up to their closing tag
+//  2. a comment, `<!--`, up to `-->`
+//  3. a processing instruction, `<?`, up to `?>`
+//  4. a declaration, `<!` and a letter, up to `>`
+//  5. `<![CDATA[`, up to `]]>`
+//  6. the opening or closing tag of a block element, up to a blank line
+//  7. any other complete tag alone on the line, up to a blank line
+func htmlBlockKind(line string) int {
+	start := countSpaces(line)
+	if start > 3 || start == len(line) || line[start] != '<' {
+		return 0
+	}
+	rest := line[start:]
+	lower := strings.ToLower(rest)
+
+	for _, tag := range rawTags {
+		if strings.HasPrefix(lower, "<"+tag) {
+			if after := len(tag) + 1; after == len(rest) || isSpace(rest[after]) || rest[after] == '>' {
+				return 1
+			}
+		}
+	}
+	switch {
+	case strings.HasPrefix(rest, "<!--"):
+		return 2
+	case strings.HasPrefix(rest, "<?"):
+		return 3
+	case strings.HasPrefix(rest, "<![CDATA["):
+		return 5
+	case len(rest) > 2 && rest[1] == '!' && isLetter(rest[2]):
+		return 4
+	}
+
+	from := 1
+	if strings.HasPrefix(rest, "</") {
+		from = 2
+	}
+	end := tagName(rest, from)
+	if end == from {
+		return 0
+	}
+	if blockTags[lower[from:end]] {
+		if end == len(rest) || isSpace(rest[end]) || rest[end] == '>' || strings.HasPrefix(rest[end:], "/>") {
+			return 6
+		}
+	}
+	if end := htmlTag(rest, 0); end > 0 && isEmpty(rest[end:]) {
+		return 7
+	}
+	return 0
+}
+
+// startsHTMLBlock reports whether line starts an HTML block which may
+// interrupt a paragraph, any but a lone tag.
+func startsHTMLBlock(line string) bool {
+	kind := htmlBlockKind(line)
+	return kind > 0 && kind < 7
+}
+
+// endsHTMLBlock reports whether line ends an HTML block of the given kind,
+// which ends with a given text.
+func endsHTMLBlock(line string, kind int) bool {
+	if kind == 1 {
+		lower := strings.ToLower(line)
+		for _, tag := range rawTags {
+			if strings.Contains(lower, "</"+tag+">") {
+				return true
+			}
+		}
+		return false
+	}
+	return strings.Contains(line, htmlEnds[kind])
+}
+
+// parseHTMLBlock parses raw HTML, kept verbatim. Blocks started by a tag
+// alone on its line can't interrupt a paragraph, those of the first five
+// kinds not ending run to the end of the document, but for its blank lines.
+func parseHTMLBlock(p *parser, index int) ([]*Token, int) {
+	kind := htmlBlockKind(p.lines[index])
+	if kind == 0 {
+		return nil, 0
+	}
+
+	end := index
+	if kind >= 6 {
+		for end < len(p.lines) && !isEmpty(p.lines[end]) {
+			end++
+		}
+	} else {
+		// The block may end on its first line.
+		for !endsHTMLBlock(p.lines[end], kind) && end+1 < len(p.lines) {
+			end++
+		}
+		closed := endsHTMLBlock(p.lines[end], kind)
+		end++
+		if !closed {
+			p.warn(p.point(index, countSpaces(p.lines[index])), "HTML block is not closed")
+			// The blank lines ending the document are left out.
+			for end > index+1 && isEmpty(p.lines[end-1]) {
+				end--
+			}
+		}
+	}
+
+	token := newToken(HTMLBlock, strings.Join(p.lines[index:end], "\n"))
+	token.Pos = p.span(index, 0, end-1)
+	return []*Token{token}, end - index
+}
+
+// htmlTag returns the index following the opening or closing tag starting at
+// the `<` at index i of s, 0 when there's no tag. Attributes are names
+// optionally followed by `=` and a value, quoted or not, and spaces in a tag
+// may be line breaks.
+func htmlTag(s string, i int) int {
+	skipSpaces := func(j int) int {
+		for j < len(s) && isSpace(s[j]) {
+			j++
+		}
+		return j
+	}
+
+	if strings.HasPrefix(s[i:], "</") {
+		end := tagName(s, i+2)
+		if end == i+2 {
+			return 0
+		}
+		end = skipSpaces(end)
+		if end < len(s) && s[end] == '>' {
+			return end + 1
+		}
+		return 0
+	}
+
+	j := tagName(s, i+1)
+	if j == i+1 {
+		return 0
+	}
+	for {
+		k := skipSpaces(j)
+		if k < len(s) && s[k] == '>' {
+			return k + 1
+		}
+		if strings.HasPrefix(s[k:], "/>") {
+			return k + 2
+		}
+		if k == j || k == len(s) || !(isLetter(s[k]) || s[k] == '_' || s[k] == ':') {
+			return 0
+		}
+
+		// An attribute.
+		j = k + 1
+		for j < len(s) && (isAlnum(s[j]) || strings.IndexByte("_.:-", s[j]) >= 0) {
+			j++
+		}
+		k = skipSpaces(j)
+		if k == len(s) || s[k] != '=' {
+			continue
+		}
+		k = skipSpaces(k + 1)
+		if k == len(s) {
+			return 0
+		}
+		if s[k] == '"' || s[k] == '\'' {
+			closing := strings.IndexByte(s[k+1:], s[k])
+			if closing < 0 {
+				return 0
+			}
+			j = k + closing + 2
+			continue
+		}
+		j = k
+		for j < len(s) && !isSpace(s[j]) && strings.IndexByte("\"'=<>`", s[j]) < 0 {
+			j++
+		}
+		if j == k {
+			return 0
+		}
+	}
+}
+
+// htmlSpan returns the index following the inline HTML starting at the `<`
+// at index i: a tag, a comment, a processing instruction, a declaration or a
+// CDATA section. It returns 0 when there's none.
+func htmlSpan(s string, i int) int {
+	rest := s[i:]
+	closing := func(start int, end string) int {
+		if found := strings.Index(rest[start:], end); found >= 0 {
+			return i + start + found + len(end)
+		}
+		return 0
+	}
+
+	switch {
+	case strings.HasPrefix(rest, "<!-->"):
+		return i + 5
+	case strings.HasPrefix(rest, "<!--->"):
+		return i + 6
+	case strings.HasPrefix(rest, "<!--"):
+		return closing(4, "-->")
+	case strings.HasPrefix(rest, "<?"):
+		return closing(2, "?>")
+	case strings.HasPrefix(rest, "<![CDATA["):
+		return closing(9, "]]>")
+	case len(rest) > 2 && rest[1] == '!' && isLetter(rest[2]):
+		return closing(2, ">")
+	}
+	return htmlTag(s, i)
+}
+
+// parseHTMLInline parses the inline HTML starting at the `<` at index i into
+// an HTMLInline token holding it verbatim.
+func parseHTMLInline(line string, i int, m sourceMap) (*Token, int) {
+	end := htmlSpan(line, i)
+	if end == 0 {
+		return nil, 0
+	}
+	token := newToken(HTMLInline, line[i:end])
+	token.Pos = Position{m.at(i), m.at(end)}
+	return token, end
+}
@@ -0,0 +1,185 @@
+package tokenizer
+
+// listMarker parses the marker starting a list item. It returns the kind of
+// the marker, items of the same kind belonging to the same list, the column
+// where the item content starts, 0 when line doesn't start an item, and the
+// attributes of the item.
+type listMarker func(line string) (byte, int, Attribute)
+
+// parseList parses the consecutive items of a list whose first item starts the
+// line index. Items may be separated by blank lines, the list being loose
+// then, as when blank lines separate the blocks of an item. It returns the
+// list with the `tight` attribute set and the number of lines consumed.
+func parseList(p *parser, index int, ttype TokenType, itemType TokenType, marker listMarker) (*Token, int) {
+	list := newToken(ttype, "")
+	kind, _, _ := marker(p.lines[index])
+	tight := true
+	end := index
+	for end < len(p.lines) {
+		k, col, attrs := marker(p.lines[end])
+		if col == 0 || k != kind {
+			break
+		}
+
+		item, skip := parseListItem(p, itemType, end, col)
+		for name, value := range attrs {
+			item.Attrs[name] = value
+		}
+		list.Children = append(list.Children, item)
+		end += skip
+		for i := 1; i < len(item.Children); i++ {
+			if item.Children[i].Pos.Start.Line > item.Children[i-1].Pos.End.Line+1 {
+				tight = false
+			}
+		}
+
+		next := end
+		for next < len(p.lines) && isEmpty(p.lines[next]) {
+			next++
+		}
+		if next > end && next < len(p.lines) {
+			if k, col, _ := marker(p.lines[next]); col > 0 && k == kind {
+				tight = false
+				end = next
+			}
+		}
+	}
+
+	list.Attrs["tight"] = tight
+	list.Pos = Position{list.Children[0].Pos.Start, list.Children[len(list.Children)-1].Pos.End}
+	return list, end - index
+}
+
+// parseListItem parses the item whose marker starts the line index, its content
+// starting at byte col. The content is tokenized into the children of the
+// item. A task list item has the `checked` attribute, its checkbox being left
+// out of the content.
+func parseListItem(p *parser, ttype TokenType, index int, col int) (*Token, int) {
+	width := columns(p.lines[index][:col])
+	if col == len(p.lines[index]) && !isEmpty(p.lines[index][col-1:]) {
+		// The content of an item whose marker ends the line would follow
+		// a space.
+		width++
+	}
+	item := newToken(ttype, "")
+	if checked, n := taskMarker(p.lines[index][col:]); n > 0 {
+		item.Attrs["checked"] = checked
+		col += n
+	}
+
+	lines, origins, lazy, end := indentedContent(p, index, col, width)
+	item.Pos = p.span(index, countSpaces(p.lines[index]), end-1)
+	item.Children = p.parseNested(lines, origins, lazy)
+	return item, end - index
+}
+
+// indentedContent returns the lines of a container block, such as a list item,
+// whose content starts at byte col of the line index, along with their origins,
+// whether they are lazy and the index of the line following the block. The following lines indented
+// by width columns belong to the block, along with the blank lines before
+// them, as well as the lines lazily continuing its last paragraph.
+func indentedContent(p *parser, index int, col int, width int) ([]string, []Point, []bool, int) {
+	lines := []string{p.lines[index][col:]}
+	origins := []Point{p.point(index, col)}
+	lazy := []bool{p.isLazy(index)}
+	content := lazyContent{}
+	content.add(lines[0])
+
+	end := index + 1
+	for end < len(p.lines) {
+		line := p.lines[end]
+		if isEmpty(line) {
+			// A block starts with at most one blank line.
+			if len(lines) == 1 && isEmpty(lines[0]) {
+				break
+			}
+			next := end
+			for next < len(p.lines) && isEmpty(p.lines[next]) {
+				next++
+			}
+			if next == len(p.lines) || indentation(p.lines[next]) < width {
+				break
+			}
+			for ; end < next; end++ {
+				lines = append(lines, "")
+				origins = append(origins, p.point(end, len(p.lines[end])))
+				lazy = append(lazy, false)
+			}
+			content.add("")
+			continue
+		}
+
+		if indentation(line) >= width {
+			n := indentBytes(line, width)
+			lines = append(lines, line[n:])
+			origins = append(origins, p.point(end, n))
+			lazy = append(lazy, p.isLazy(end))
+		} else if content.continues(line) {
+			n := indentBytes(line, indentation(line))
+			lines = append(lines, line[n:])
+			origins = append(origins, p.point(end, n))
+			lazy = append(lazy, true)
+		} else {
+			break
+		}
+		content.add(lines[len(lines)-1])
+		end++
+	}
+	return lines, origins, lazy, end
+}
+
+// advance returns the column following a space or tab at column width, tabs
+// advancing to the next multiple of 4 columns.
+func advance(width int, c byte) int {
+	if c == '\t' {
+		return width + 4 - width%4
+	}
+	return width + 1
+}
+
+// columns returns the width of s.
+func columns(s string) int {
+	width := 0
+	for i := 0; i < len(s); i++ {
+		width = advance(width, s[i])
+	}
+	return width
+}
+
+// indentation returns the width of the spaces and tabs starting line.
+func indentation(line string) int {
+	width := 0
+	for i := 0; i < len(line) && (line[i] == ' ' || line[i] == '\t'); i++ {
+		width = advance(width, line[i])
+	}
+	return width
+}
+
+// indentBytes returns the number of bytes of the indentation of line covering
+// width columns. A tab reaching beyond them is included.
+func indentBytes(line string, width int) int {
+	n, w := 0, 0
+	for n < len(line) && (line[n] == ' ' || line[n] == '\t') && w < width {
+		w = advance(w, line[n])
+		n++
+	}
+	return n
+}
+
+// contentColumn returns the column where the content of a list item starts,
+// given the column following its marker. The content is separated from the
+// marker by up to 4 spaces or tabs, more of them being part of the content.
+// It is the end of the line when the marker ends it.
+func contentColumn(line string, col int) int {
+	spaces := 0
+	for col+spaces < len(line) && (line[col+spaces] == ' ' || line[col+spaces] == '\t') {
+		spaces++
+	}
+	if col == len(line) {
+		return col
+	}
+	if spaces > 4 || col+spaces == len(line) {
+		return col + 1
+	}
+	return col + spaces
+}
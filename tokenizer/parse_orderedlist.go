@@ -1,44 +1,63 @@
 package tokenizer
 
-import (
-	"strconv"
-	"strings"
-)
+import "strconv"
 
 const (
 	OrderedList     TokenType = "OrderedList"
 	OrderedListItem TokenType = "OrderedListItem"
 )
 
-func parseOrderedList(lines []string, index int) ([]*Token, int) {
-	if isEmpty(lines[index]) {
-		return nil, 0
+// orderedMarker parses the marker of an ordered item, a number of up to 9
+// digits followed by `.` or `)` and a space, a tab or the end of the line. It
+// returns the number, the delimiter and the column where the item content
+// starts, 0 when line doesn't start an item.
+func orderedMarker(line string) (int, byte, int) {
+	spaces := countSpaces(line)
+	if spaces > 3 {
+		return 0, 0, 0
+	}
+
+	digits := spaces
+	for digits < len(line) && digits-spaces < 9 && line[digits] >= '0' && line[digits] <= '9' {
+		digits++
+	}
+	if digits == spaces || digits >= len(line) {
+		return 0, 0, 0
+	}
+	if digits+1 < len(line) && line[digits+1] != ' ' && line[digits+1] != '\t' {
+		return 0, 0, 0
+	}
+	delimiter := line[digits]
+	if delimiter != '.' && delimiter != ')' {
+		return 0, 0, 0
 	}
 
-	skip := 0
-	list := newToken(OrderedList, "")
-	current := list
-	for index < len(lines) {
-		if isEmpty(lines[index]) {
-			break
-		}
-
-		spaces := countSpaces(lines[index])
-		slices := strings.SplitN(lines[index][spaces:], ". ", 2)
-
-		_, err := strconv.Atoi(slices[0])
-		if err != nil && spaces == 0 {
-			break
-		}
-
-		token := newToken(OrderedListItem, "")
-		token.Children = parseSpans(slices[1])
-		token.Attrs["id"] = skip + 1
-
-		current.Children = append(current.Children, token)
-		skip += 1
-		index += 1
+	number, _ := strconv.Atoi(line[spaces:digits])
+	return number, delimiter, contentColumn(line, digits+1)
+}
+
+// isOrderedItem reports whether line starts an item.
+func isOrderedItem(line string) bool {
+	_, _, col := orderedMarker(line)
+	return col > 0
+}
+
+func orderedItemMarker(line string) (byte, int, Attribute) {
+	number, delimiter, col := orderedMarker(line)
+	return delimiter, col, Attribute{"id": number}
+}
+
+// parseOrderedList parses consecutive items using the same delimiter. The
+// list starts at the number of its first item, which is also its `start`
+// attribute, and every item has its written number as `id`.
+func parseOrderedList(p *parser, index int) ([]*Token, int) {
+	start, delimiter, col := orderedMarker(p.lines[index])
+	if col == 0 {
+		return nil, 0
 	}
 
+	list, skip := parseList(p, index, OrderedList, OrderedListItem, orderedItemMarker)
+	list.Attrs["start"] = start
+	list.Attrs["delimiter"] = string(delimiter)
 	return []*Token{list}, skip
 }
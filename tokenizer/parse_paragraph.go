@@ -0,0 +1,126 @@
+package tokenizer
+
+import "strings"
+
+const (
+	Paragraph TokenType = "Paragraph"
+)
+
+// interruptsParagraph reports whether line starts a block, ending the
+// paragraph before it. An ordered list must start at 1 to do so, and an item
+// must not be empty.
+func interruptsParagraph(line string) bool {
+	_, bulletCol := bulletMarker(line)
+	number, _, col := orderedMarker(line)
+	return isHr(line) || headingLevel(line) > 0 || isFence(line) || isQuote(line) ||
+		bulletCol > 0 && !isEmpty(line[bulletCol:]) || col > 0 && number == 1 && !isEmpty(line[col:]) ||
+		isFootnoteDefinition(line) || startsHTMLBlock(line)
+}
+
+// lazyContent follows the lines of a container block, such as a blockquote,
+// to tell whether a line lacking the container marker or indentation still
+// belongs to it, by lazily continuing the paragraph the container ends with.
+type lazyContent struct {
+	last string
+	// fence opens the code block the content is in.
+	fence string
+}
+
+// add records a line of the container content.
+func (c *lazyContent) add(line string) {
+	// The paragraph may be in a nested container.
+	for {
+		if marker := quoteMarker(line); marker > 0 {
+			line = line[marker:]
+		} else if _, col := bulletMarker(line); col > 0 {
+			line = line[col:]
+		} else if _, _, col := orderedMarker(line); col > 0 {
+			line = line[col:]
+		} else {
+			break
+		}
+	}
+
+	if c.fence != "" {
+		if closesFence(line, c.fence) {
+			c.fence = ""
+		}
+		line = ""
+	} else if marker, _ := fence(line); marker != "" {
+		c.fence = marker
+		line = ""
+	}
+	c.last = line
+}
+
+// continues reports whether line continues the paragraph ending the content.
+// Outside of the container, any list item starts a new list.
+func (c *lazyContent) continues(line string) bool {
+	return c.fence == "" && !isEmpty(c.last) && !interruptsParagraph(c.last) &&
+		!interruptsParagraph(line) && !isList(line) && !isOrderedItem(line)
+}
+
+// setextLevel returns the level of the heading a line of `=` or `-` makes of
+// the paragraph it underlines, 0 when line isn't an underline. It may be
+// indented by up to 3 spaces and followed by spaces.
+func setextLevel(line string) int {
+	spaces := countSpaces(line)
+	if spaces > 3 {
+		return 0
+	}
+	underline := strings.TrimRight(line[spaces:], " \t")
+	switch {
+	case underline == "":
+		return 0
+	case strings.Trim(underline, "=") == "":
+		return 1
+	case strings.Trim(underline, "-") == "":
+		return 2
+	}
+	return 0
+}
+
+// parseParagraph parses consecutive lines of text, up to a blank line or the
+// start of another block. The lines are stripped of their indentation. The
+// paragraph is a heading when its lines are underlined with `=` or `-`.
+func parseParagraph(p *parser, index int) ([]*Token, int) {
+	if isEmpty(p.lines[index]) {
+		return nil, 0
+	}
+
+	lines := []string{}
+	m := sourceMap{}
+	offset := 0
+	end := index
+	level := 0
+	for end < len(p.lines) && !isEmpty(p.lines[end]) {
+		// A lazy line is paragraph text.
+		if end > index && !p.isLazy(end) {
+			if level = setextLevel(p.lines[end]); level > 0 {
+				break
+			}
+			if interruptsParagraph(p.lines[end]) || tableAlignments(p, end) != nil {
+				break
+			}
+		}
+		spaces := countSpaces(p.lines[end])
+		m = append(m, segment{offset, p.point(end, spaces)})
+		lines = append(lines, p.lines[end][spaces:])
+		offset += len(p.lines[end]) - spaces + 1
+		end++
+	}
+	lines[len(lines)-1] = strings.TrimRight(lines[len(lines)-1], " ")
+
+	if level > 0 {
+		text := strings.Join(lines, "\n")
+		heading := newToken(headings[level], text)
+		heading.Pos = Position{m.at(0), p.point(end, len(p.lines[end]))}
+		p.inline(heading, text, m)
+		return []*Token{heading}, end - index + 1
+	}
+
+	paragraph := newToken(Paragraph, "")
+	paragraph.Pos = Position{m.at(0), p.point(end-1, len(p.lines[end-1]))}
+	p.inline(paragraph, strings.Join(lines, "\n"), m)
+	return []*Token{paragraph}, end - index
+}
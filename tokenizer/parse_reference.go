@@ -0,0 +1,102 @@
+package tokenizer
+
+import "strings"
+
+// Reference is the destination of the reference links and images using a
+// label, set by a link reference definition: `[label]: url "title"`.
+type Reference struct {
+	URL   string `json:"url"`
+	Title string `json:"title,omitempty"`
+}
+
+// normalizeLabel returns the key of a reference label: labels match case
+// insensitively, their runs of spaces and line breaks counting as one space.
+func normalizeLabel(label string) string {
+	return strings.ToLower(strings.Join(strings.Fields(label), " "))
+}
+
+// definition parses `[label]: url "title"`, the url possibly between `<` and
+// `>`, and the title between `"`, `'` or parentheses and optional.
+func definition(line string) (string, Reference, bool) {
+	start := countSpaces(line)
+	if start > 3 || start >= len(line) || line[start] != '[' {
+		return "", Reference{}, false
+	}
+	closing := strings.IndexByte(line[start+1:], ']')
+	if closing < 0 {
+		return "", Reference{}, false
+	}
+	closing += start + 1
+	label := line[start+1 : closing]
+	if isEmpty(label) || strings.Contains(label, "[") || closing+1 >= len(line) || line[closing+1] != ':' {
+		return "", Reference{}, false
+	}
+
+	rest := strings.TrimLeft(line[closing+2:], " \t")
+	var url string
+	if strings.HasPrefix(rest, "<") {
+		end := strings.IndexByte(rest, '>')
+		if end < 0 {
+			return "", Reference{}, false
+		}
+		url, rest = rest[1:end], rest[end+1:]
+	} else {
+		end := strings.IndexAny(rest, " \t")
+		if end < 0 {
+			end = len(rest)
+		}
+		if end == 0 {
+			return "", Reference{}, false
+		}
+		url, rest = rest[:end], rest[end:]
+	}
+
+	title := strings.TrimSpace(rest)
+	if title == "" {
+		return label, Reference{URL: unescape(url)}, true
+	}
+	if len(title) < 2 || rest[0] != ' ' && rest[0] != '\t' {
+		return "", Reference{}, false
+	}
+	closer := map[byte]byte{'"': '"', '\'': '\'', '(': ')'}[title[0]]
+	if closer == 0 || title[len(title)-1] != closer {
+		return "", Reference{}, false
+	}
+	return label, Reference{URL: unescape(url), Title: unescape(title[1 : len(title)-1])}, true
+}
+
+// parseDefinition parses a link reference definition, which gives no token: it
+// is recorded in the document, the first definition of a label being used.
+// A definition can't interrupt a paragraph.
+func parseDefinition(p *parser, index int) ([]*Token, int) {
+	label, ref, ok := definition(p.lines[index])
+	if !ok {
+		return nil, 0
+	}
+
+	key := normalizeLabel(label)
+	if _, found := p.doc.references[key]; !found {
+		p.doc.references[key] = ref
+	}
+	return []*Token{}, 1
+}
+
+// destination parses the target of an inline link, `url "title"`, the title
+// being optional and the url possibly between `<` and `>`.
+func destination(target string) Reference {
+	target = strings.TrimSpace(target)
+	ref := Reference{URL: target}
+	if n := len(target); n >= 2 && (target[n-1] == '"' || target[n-1] == '\'') {
+		q := n - 2
+		for q > 0 && (target[q] != target[n-1] || target[q-1] == '\\') {
+			q--
+		}
+		if q > 0 && isSpace(target[q-1]) {
+			ref = Reference{URL: strings.TrimSpace(target[:q]), Title: target[q+1 : n-1]}
+		}
+	}
+	if n := len(ref.URL); n >= 2 && ref.URL[0] == '<' && ref.URL[n-1] == '>' {
+		ref.URL = ref.URL[1 : n-1]
+	}
+	return Reference{URL: unescape(ref.URL), Title: unescape(ref.Title)}
+}
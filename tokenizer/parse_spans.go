@@ -1,181 +1,524 @@
 package tokenizer
 
+import "strings"
+
 const (
 	Text      TokenType = "Text"
-	Bold      TokenType = "Bold"
-	EndBold   TokenType = "EndBold"
-	Italic    TokenType = "Italic"
-	EndItalic TokenType = "EndItalic"
+	Strong    TokenType = "Strong"
+	Emphasis  TokenType = "Emphasis"
 	Link      TokenType = "Link"
 	Image     TokenType = "Image"
+	SoftBreak TokenType = "SoftBreak"
+	HardBreak TokenType = "HardBreak"
+	CodeSpan  TokenType = "CodeSpan"
+
+	Strikethrough TokenType = "Strikethrough"
+	Highlight     TokenType = "Highlight"
+	Subscript     TokenType = "Subscript"
+	Superscript   TokenType = "Superscript"
 )
 
-type gap struct {
-	start int
-	end   int
-	ttype string
+// extensionSpans maps the delimiter runs of the optional spans to the token
+// they wrap their content into and the extension enabling them. Unlike
+// emphasis, such a run only matches a run of the same length.
+var extensionSpans = map[string]struct {
+	ttype TokenType
+	ext   Extensions
+}{
+	"~~": {Strikethrough, ExtStrikethrough},
+	"==": {Highlight, ExtHighlight},
+	"~":  {Subscript, ExtSubscript},
+	"^":  {Superscript, ExtSuperscript},
+}
+
+// delimiter is a run of `*` or `_` which may open or close emphasis, or of
+// `~`, `=` or `^` opening or closing an extension span. The
+// characters left are line[from:to], openers being consumed from the end and
+// closers from the start.
+type delimiter struct {
+	char     byte
+	length   int
+	from     int
+	to       int
+	canOpen  bool
+	canClose bool
 }
 
+func (d *delimiter) count() int {
+	return d.to - d.from
+}
+
+// span is an element of the flat list built while scanning a line: a token,
+// or a delimiter run waiting to be matched.
 type span struct {
-	value string
-	ttype string
+	token *Token
+	delim *delimiter
 }
 
-func last(arr []gap) *gap {
-	return &arr[len(arr)-1]
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
 }
 
-func parseImage(line string, i int) []gap {
-	i += 1
-	if i >= len(line) || line[i] != '[' {
-		return []gap{}
-	}
+func isPunct(c byte) bool {
+	return (c >= '!' && c <= '/') || (c >= ':' && c <= '@') || (c >= '[' && c <= '`') || (c >= '{' && c <= '~')
+}
 
-	i++
-	alt := gap{i, i, "img-alt"}
-	for i < len(line) && line[i] != ']' {
-		alt.end = i
-		i++
+// newDelimiter classifies the run line[start:end] using the flanking rules:
+// a run opens when followed by text, closes when preceded by text, and `_`
+// doesn't open or close within a word.
+func newDelimiter(line string, start int, end int) *delimiter {
+	before, after := byte(' '), byte(' ')
+	if start > 0 {
+		before = line[start-1]
 	}
-
-	i++
-	if line[i] != '(' {
-		return []gap{}
+	if end < len(line) {
+		after = line[end]
 	}
 
-	i++
-	src := gap{i, 0, "img-src"}
-	for i < len(line) && line[i] != ')' {
-		src.end = i
-		i++
-	}
+	left := !isSpace(after) && (!isPunct(after) || isSpace(before) || isPunct(before))
+	right := !isSpace(before) && (!isPunct(before) || isSpace(after) || isPunct(after))
 
-	return []gap{alt, src}
+	d := &delimiter{char: line[start], length: end - start, from: start, to: end}
+	if d.char == '_' {
+		d.canOpen = left && (!right || isPunct(before))
+		d.canClose = right && (!left || isPunct(after))
+	} else {
+		d.canOpen = left
+		d.canClose = right
+	}
+	return d
 }
 
-func parseLink(line string, i int) []gap {
-	i += 1
-
-	txt := gap{i, 0, "link-txt"}
-	for i < len(line) && line[i] != ']' {
-		txt.end = i
-		i++
+// parseImage parses `![alt](src "title")`, or `![alt][label]` and the other
+// forms of reference, starting at the `!` at index i. It returns the image and
+// the index following it, or nil when there's no image.
+func parseImage(p *parser, line string, i int, m sourceMap) (*Token, int) {
+	if i+1 >= len(line) || line[i+1] != '[' {
+		return nil, 0
 	}
 
-	i++
-	if line[i] != '(' {
-		return []gap{}
+	if strings.IndexByte(line[i+2:], ']') < 0 {
+		p.warn(m.at(i), "image description is not closed")
+		return nil, 0
 	}
 
-	i++
-	url := gap{i, 0, "link-url"}
-	for i < len(line) && line[i] != ')' {
-		url.end = i
-		i++
+	alt, ref, end := parseBrackets(p, line, i+1, m)
+	if end == 0 {
+		return nil, 0
 	}
 
-	return []gap{txt, url}
+	token := newToken(Image, "")
+	token.Attrs["alt"] = unescape(alt)
+	token.Attrs["src"] = ref.URL
+	if ref.Title != "" {
+		token.Attrs["title"] = ref.Title
+	}
+	token.Pos = Position{m.at(i), m.at(end)}
+	return token, end
 }
 
-func addOrCloseGap(gaps *[]gap, ttype string, i int, count int) int {
-	if len(*gaps) > 0 && last(*gaps).ttype == ttype && last(*gaps).end+1 == i {
-		last(*gaps).end += 1
-		return 0
+// parseLink parses `[text](url "title")`, or `[text][label]` and the other
+// forms of reference, starting at the `[` at index i. The text of the link is
+// parsed into its children.
+func parseLink(p *parser, line string, i int, m sourceMap) (*Token, int) {
+	text, ref, end := parseBrackets(p, line, i, m)
+	if end == 0 {
+		return nil, 0
 	}
 
-	if count%2 == 0 {
-		*gaps = append(*gaps, gap{i + 1, i, ttype})
-		return 1
+	token := newToken(Link, text)
+	token.Attrs["url"] = ref.URL
+	if ref.Title != "" {
+		token.Attrs["title"] = ref.Title
 	}
+	token.Pos = Position{m.at(i), m.at(end)}
+	token.Children = parseInlines(p, text, m.slice(i+1, i+1+len(text)))
+	return token, end
+}
 
-	*gaps = append(*gaps, gap{i + 1, i, "end" + ttype})
-	return 0
+// closingParen returns the index of the `)` ending the destination and the
+// title of a link in s, -1 when there's none. The parentheses of the
+// destination must be balanced, except within `<…>` or the quoted title.
+func closingParen(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case isEscape(s, i):
+			i++
+		case c == '<' && isEmpty(s[:i]), (c == '"' || c == '\'') && i > 0 && isSpace(s[i-1]):
+			closing := c
+			if c == '<' {
+				closing = '>'
+			}
+			if end := indexUnescaped(s[i+1:], closing); end >= 0 {
+				i += end + 1
+			}
+		case c == '(':
+			depth++
+		case c == ')':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
 }
 
-func parseGaps(line string) []gap {
-	i := 0
-	gaps := []gap{}
-	italics := 0
-	und_bolds := 0
-	star_bolds := 0
+// parseBrackets parses `[text](target)` starting at the `[` at index i, or a
+// reference to a definition: `[text][label]`, `[text][]` or `[text]`, the
+// last two using the text as label. The returned end is 0 when the brackets
+// aren't closed or the label isn't defined.
+func parseBrackets(p *parser, line string, i int, m sourceMap) (string, Reference, int) {
+	closing := closingBracket(line, i+1)
+	if closing < 0 {
+		return "", Reference{}, 0
+	}
+	text := line[i+1 : closing]
 
-	for i < len(line) {
-		if line[i] == '!' {
-			if img := parseImage(line, i); len(img) > 1 {
-				gaps = append(gaps, img...)
-				i = img[1].end + 2
-				continue
-			}
+	if closing+1 < len(line) && line[closing+1] == '(' {
+		end := closingParen(line[closing+2:])
+		if end < 0 {
+			p.warn(m.at(closing+1), "link destination is not closed")
+			return "", Reference{}, 0
 		}
-		if line[i] == '[' {
-			if link := parseLink(line, i); len(link) > 1 {
-				gaps = append(gaps, link...)
-				i = link[1].end + 2
-				continue
+		end += closing + 2
+		return text, destination(line[closing+2 : end]), end + 1
+	}
+
+	label, end := text, closing+1
+	if end < len(line) && line[end] == '[' {
+		if labelEnd := strings.IndexByte(line[end+1:], ']'); labelEnd >= 0 {
+			labelEnd += end + 1
+			if labelEnd > end+1 {
+				label = line[end+1 : labelEnd]
 			}
+			end = labelEnd + 1
 		}
-		if line[i] == '*' || line[i] == '_' {
-			if i+1 < len(line) && line[i] == '*' && line[i+1] == '*' {
-				star_bolds += addOrCloseGap(&gaps, "bold", i, star_bolds)
+	}
+	ref, found := p.doc.references[normalizeLabel(label)]
+	if !found {
+		return "", Reference{}, 0
+	}
+	return text, ref, end
+}
+
+// closingBracket returns the index of the first `]` from index i, code spans
+// and escaped brackets excepted, or -1.
+func closingBracket(line string, i int) int {
+	for i < len(line) {
+		switch line[i] {
+		case '\\':
+			if isEscape(line, i) {
 				i += 2
 				continue
 			}
-			if i+1 < len(line) && line[i] == '_' && line[i+1] == '_' {
-				und_bolds += addOrCloseGap(&gaps, "bold", i, und_bolds)
-				i += 2
+		case ']':
+			return i
+		case '`':
+			n := backticks(line, i)
+			if end := closeCodeSpan(line, i+n, n); end >= 0 {
+				i = end + n
 				continue
 			}
-			italics += addOrCloseGap(&gaps, "italic", i, italics)
+			i += n
+			continue
+		}
+		i++
+	}
+	return -1
+}
+
+// backticks returns the length of the run of backticks starting at index i.
+func backticks(line string, i int) int {
+	n := 0
+	for i+n < len(line) && line[i+n] == '`' {
+		n++
+	}
+	return n
+}
+
+// closeCodeSpan returns the index of the run of exactly n backticks closing a
+// code span whose content starts at index i, or -1.
+func closeCodeSpan(line string, i int, n int) int {
+	for i < len(line) {
+		if line[i] != '`' {
 			i++
 			continue
 		}
+		run := backticks(line, i)
+		if run == n {
+			return i
+		}
+		i += run
+	}
+	return -1
+}
+
+// parseCodeSpan parses the code span opened by the run of backticks at index
+// i. Line breaks in the code become spaces, and a space on both sides of it is
+// stripped unless it's only made of spaces. Nothing is parsed within the code.
+func parseCodeSpan(line string, i int, m sourceMap) (*Token, int) {
+	n := backticks(line, i)
+	closing := closeCodeSpan(line, i+n, n)
+	if closing < 0 {
+		return nil, 0
+	}
+
+	code := strings.ReplaceAll(line[i+n:closing], "\n", " ")
+	if len(code) >= 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+		code = code[1 : len(code)-1]
+	}
+
+	token := newToken(CodeSpan, code)
+	token.Pos = Position{m.at(i), m.at(closing + n)}
+	return token, closing + n
+}
+
+// isEmphasis reports whether d is a run of emphasis rather than of an
+// extension span.
+func (d *delimiter) isEmphasis() bool {
+	return d.char == '*' || d.char == '_'
+}
+
+// findOpener looks for the delimiter matching the closer at index closer.
+func findOpener(spans []*span, closer int, line string) int {
+	c := spans[closer].delim
+	for i := closer - 1; i >= 0; i-- {
+		o := spans[i].delim
+		if o == nil || o.char != c.char || !o.canOpen || o.count() == 0 {
+			continue
+		}
+		if !c.isEmphasis() {
+			// Subscripts and superscripts can't contain spaces.
+			if o.length != c.length || c.length == 1 && strings.ContainsAny(line[o.to:c.from], " \t\n") {
+				continue
+			}
+			return i
+		}
+		// A run that can both open and close can't be matched with another
+		// one when their lengths add up to a multiple of 3, `*a**b*` is an
+		// emphasis containing `a**b`.
+		if (o.canClose || c.canOpen) && (o.length+c.length)%3 == 0 && (o.length%3 != 0 || c.length%3 != 0) {
+			continue
+		}
+		return i
+	}
+	return -1
+}
 
-		if len(gaps) > 0 && last(gaps).ttype == "normal" && last(gaps).end+1 == i {
-			last(gaps).end += 1
-		} else {
-			gaps = append(gaps, gap{i, i, "normal"})
+// processEmphasis matches the delimiters of spans, wrapping the spans between
+// an opener and a closer into a Strong or Emphasis token, or the token of an
+// extension span. Unmatched delimiters are kept as text.
+func processEmphasis(spans []*span, line string, m sourceMap) []*Token {
+	for closer := 0; closer < len(spans); closer++ {
+		c := spans[closer].delim
+		if c == nil || !c.canClose {
+			continue
 		}
 
-		i++
+		for c.count() > 0 {
+			opener := findOpener(spans, closer, line)
+			if opener < 0 {
+				break
+			}
+
+			o := spans[opener].delim
+			n, ttype := 1, Emphasis
+			if !c.isEmphasis() {
+				n, ttype = c.length, extensionSpans[line[c.from:c.to]].ttype
+			} else if o.count() >= 2 && c.count() >= 2 {
+				n, ttype = 2, Strong
+			}
+			o.to -= n
+			c.from += n
+
+			token := newToken(ttype, "")
+			token.Pos = Position{m.at(o.to), m.at(c.from)}
+			token.Children = tokensOf(spans[opener+1:closer], line, m)
+
+			rest := append([]*span{{token: token}}, spans[closer:]...)
+			spans = append(spans[:opener+1], rest...)
+			closer = opener + 2
+		}
 	}
 
-	return gaps
+	return tokensOf(spans, line, m)
 }
 
+// tokensOf turns spans into tokens, the characters left of delimiter runs
+// becoming text. Adjacent text tokens are merged.
+func tokensOf(spans []*span, line string, m sourceMap) []*Token {
+	tokens := []*Token{}
+	for _, s := range spans {
+		token := s.token
+		if s.delim != nil {
+			if s.delim.count() == 0 {
+				continue
+			}
+			token = newToken(Text, line[s.delim.from:s.delim.to])
+			token.Pos = Position{m.at(s.delim.from), m.at(s.delim.to)}
+		}
+
+		if len(tokens) > 0 && token.Ttype == Text && tokens[len(tokens)-1].Ttype == Text {
+			previous := tokens[len(tokens)-1]
+			merged := newToken(Text, previous.Value+token.Value)
+			merged.Pos = Position{previous.Pos.Start, token.Pos.End}
+			if previous.source != nil || token.source != nil {
+				merged.source = previous.sourceOf()
+				for _, s := range token.sourceOf() {
+					merged.source = append(merged.source, segment{len(previous.Value) + s.offset, s.point})
+				}
+			}
+			tokens[len(tokens)-1] = merged
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+// parseSpans parses the inline content of a line into a tree of tokens.
 func parseSpans(line string) []*Token {
-	gaps := parseGaps(line)
+	return parseSpansAt(NewParser(""), line, sourceMap{{0, Point{Line: 1, Column: 1}}})
+}
 
-	translator := map[string]TokenType{
-		"normal":    Text,
-		"bold":      Bold,
-		"endbold":   EndBold,
-		"italic":    Italic,
-		"enditalic": EndItalic,
+// parseSpansAt parses inline content, m locating it in the source. The lines
+// of the content are separated by a SoftBreak, or a HardBreak when the line
+// ends with two spaces or a backslash. A backslash before an ASCII
+// punctuation character makes it text, and entities are decoded.
+func parseSpansAt(p *parser, line string, m sourceMap) []*Token {
+	tokens := parseInlines(p, line, m)
+	if p.extensions.Has(ExtLinkify) {
+		tokens = linkify(tokens)
 	}
+	return tokens
+}
 
-	tokens := []*Token{}
+// parseInlines is parseSpansAt without the linkify extension, which doesn't
+// apply to the text of links.
+func parseInlines(p *parser, line string, m sourceMap) []*Token {
+	spans := []*span{}
+	textStart := 0
+
+	flush := func(end int) {
+		if end > textStart {
+			text := newToken(Text, line[textStart:end])
+			text.Pos = Position{m.at(textStart), m.at(end)}
+			spans = append(spans, &span{token: text})
+		}
+	}
 
 	i := 0
-	for i < len(gaps) {
-		if gaps[i].ttype == "img-alt" {
-			newToken := newToken(Image, "")
-			newToken.Attrs["alt"] = line[gaps[i].start : gaps[i].end+1]
-			newToken.Attrs["src"] = line[gaps[i+1].start : gaps[i+1].end+1]
-			tokens = append(tokens, newToken)
-			i += 2
+	// text adds a Text token for line[i:end], standing for value found at
+	// the index from.
+	text := func(value string, from int, end int) {
+		flush(i)
+		token := newToken(Text, value)
+		token.Pos = Position{m.at(i), m.at(end)}
+		token.source = sourceMap{{0, m.at(from)}, {len(value), m.at(end)}}
+		spans = append(spans, &span{token: token})
+		i, textStart = end, end
+	}
+
+	for i < len(line) {
+		switch line[i] {
+		case '\\':
+			if isEscape(line, i) {
+				text(line[i+1:i+2], i+1, i+2)
+				continue
+			}
+		case '&':
+			if value, end := entity(line, i); end > 0 {
+				text(value, i, end)
+				continue
+			}
+		case '!':
+			if token, end := parseImage(p, line, i, m); token != nil {
+				flush(i)
+				spans = append(spans, &span{token: token})
+				i, textStart = end, end
+				continue
+			}
+		case '<':
+			if token, end := parseAutolink(line, i, m); token != nil {
+				flush(i)
+				spans = append(spans, &span{token: token})
+				i, textStart = end, end
+				continue
+			}
+			if token, end := parseHTMLInline(line, i, m); token != nil {
+				flush(i)
+				spans = append(spans, &span{token: token})
+				i, textStart = end, end
+				continue
+			}
+		case '[':
+			if token, end := parseFootnoteRef(p, line, i, m); token != nil {
+				flush(i)
+				spans = append(spans, &span{token: token})
+				i, textStart = end, end
+				continue
+			}
+			if token, end := parseLink(p, line, i, m); token != nil {
+				flush(i)
+				spans = append(spans, &span{token: token})
+				i, textStart = end, end
+				continue
+			}
+		case '\n':
+			end := i
+			for end > textStart && line[end-1] == ' ' {
+				end--
+			}
+			br := newToken(SoftBreak, "")
+			if i-end >= 2 {
+				br.Ttype = HardBreak
+			} else if end > textStart && line[end-1] == '\\' {
+				br.Ttype = HardBreak
+				end--
+			}
+			br.Pos = Position{m.at(end), m.at(i).advance(1)}
+			flush(end)
+			spans = append(spans, &span{token: br})
+			i, textStart = i+1, i+1
 			continue
-		}
-		if gaps[i].ttype == "link-txt" {
-			newToken := newToken(Link, line[gaps[i].start:gaps[i].end+1])
-			newToken.Attrs["url"] = line[gaps[i+1].start : gaps[i+1].end+1]
-			tokens = append(tokens, newToken)
-			i += 2
+		case '`':
+			if token, end := parseCodeSpan(line, i, m); token != nil {
+				flush(i)
+				spans = append(spans, &span{token: token})
+				i, textStart = end, end
+				continue
+			}
+			// An unmatched run is text, its backticks can't open a shorter span.
+			i += backticks(line, i)
+			continue
+		case '*', '_':
+			flush(i)
+			end := i
+			for end < len(line) && line[end] == line[i] {
+				end++
+			}
+			spans = append(spans, &span{delim: newDelimiter(line, i, end)})
+			i, textStart = end, end
+			continue
+		case '~', '=', '^':
+			end := i
+			for end < len(line) && line[end] == line[i] {
+				end++
+			}
+			if s, found := extensionSpans[line[i:end]]; found && p.extensions.Has(s.ext) {
+				flush(i)
+				spans = append(spans, &span{delim: newDelimiter(line, i, end)})
+				textStart = end
+			}
+			i = end
 			continue
 		}
-		ttype, _ := translator[gaps[i].ttype]
-		tokens = append(tokens, newToken(ttype, line[gaps[i].start:gaps[i].end+1]))
 		i++
 	}
+	flush(len(line))
 
-	return tokens
+	return processEmphasis(spans, line, m)
 }
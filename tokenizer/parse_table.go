@@ -0,0 +1,173 @@
+package tokenizer
+
+import "strings"
+
+const (
+	Table     TokenType = "Table"
+	TableRow  TokenType = "TableRow"
+	TableCell TokenType = "TableCell"
+)
+
+// cell is the content of a table cell, with its source map and position.
+type cell struct {
+	text string
+	m    sourceMap
+	pos  Position
+}
+
+func isBlank(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+// splitRow splits the line index of a table at its pipes, the leading and
+// trailing ones being optional. A pipe escaped with a backslash is part of the
+// cell, the backslash being dropped.
+func splitRow(p *parser, index int) []cell {
+	line := p.lines[index]
+	start, end := 0, len(line)
+	for start < end && isBlank(line[start]) {
+		start++
+	}
+	for end > start && isBlank(line[end-1]) {
+		end--
+	}
+	if start < end && line[start] == '|' {
+		start++
+	}
+	if end > start && line[end-1] == '|' && line[end-2] != '\\' {
+		end--
+	}
+
+	cells := []cell{}
+	from := start
+	for i := start; i <= end; i++ {
+		if i < end && (line[i] != '|' || line[i-1] == '\\') {
+			continue
+		}
+		cells = append(cells, newCell(p, index, from, i))
+		from = i + 1
+	}
+	return cells
+}
+
+// newCell returns the cell between the columns from and to of the line index,
+// stripped of its spaces.
+func newCell(p *parser, index int, from int, to int) cell {
+	line := p.lines[index]
+	for from < to && isBlank(line[from]) {
+		from++
+	}
+	for to > from && isBlank(line[to-1]) {
+		to--
+	}
+
+	c := cell{pos: Position{p.point(index, from), p.point(index, to)}}
+	var text strings.Builder
+	piece := from
+	for i := from; i < to; i++ {
+		if line[i] == '\\' && i+1 < to && line[i+1] == '|' {
+			c.m = append(c.m, segment{text.Len(), p.point(index, piece)})
+			text.WriteString(line[piece:i])
+			piece = i + 1
+		}
+	}
+	c.m = append(c.m, segment{text.Len(), p.point(index, piece)})
+	text.WriteString(line[piece:to])
+	c.text = text.String()
+	return c
+}
+
+// alignment returns the alignment of a column given its delimiter cell, and
+// whether the cell is a delimiter made of dashes and optional colons.
+func alignment(delimiter string) (string, bool) {
+	dashes := strings.TrimSuffix(strings.TrimPrefix(delimiter, ":"), ":")
+	if dashes == "" || strings.Trim(dashes, "-") != "" {
+		return "", false
+	}
+
+	left := strings.HasPrefix(delimiter, ":")
+	right := strings.HasSuffix(delimiter, ":")
+	switch {
+	case left && right:
+		return "center", true
+	case left:
+		return "left", true
+	case right:
+		return "right", true
+	}
+	return "", true
+}
+
+// tableAlignments returns the alignment of the columns of a table whose
+// header is the line index, nil when the next line isn't a delimiter row with
+// as many cells as the header.
+func tableAlignments(p *parser, index int) []string {
+	if index+1 >= len(p.lines) {
+		return nil
+	}
+	header, delimiters := p.lines[index], p.lines[index+1]
+	if !strings.Contains(header, "|") || !strings.Contains(delimiters, "|") || !strings.Contains(delimiters, "-") {
+		return nil
+	}
+
+	cells := splitRow(p, index+1)
+	if len(cells) != len(splitRow(p, index)) {
+		return nil
+	}
+	aligns := []string{}
+	for _, c := range cells {
+		align, ok := alignment(c.text)
+		if !ok {
+			return nil
+		}
+		aligns = append(aligns, align)
+	}
+	return aligns
+}
+
+// parseTable parses a table made of a header row, a delimiter row setting the
+// alignment of the columns, and body rows up to a blank line or the start of
+// another block. Body rows are cut or padded with empty cells to the number of
+// columns of the header, which has the `header` attribute.
+func parseTable(p *parser, index int) ([]*Token, int) {
+	aligns := tableAlignments(p, index)
+	if aligns == nil {
+		return nil, 0
+	}
+
+	table := newToken(Table, "")
+	header := p.tableRow(index, aligns)
+	header.Attrs["header"] = true
+	table.Children = append(table.Children, header)
+
+	end := index + 2
+	for end < len(p.lines) && !isEmpty(p.lines[end]) && !interruptsParagraph(p.lines[end]) {
+		table.Children = append(table.Children, p.tableRow(end, aligns))
+		end++
+	}
+
+	table.Pos = p.span(index, countSpaces(p.lines[index]), end-1)
+	return []*Token{table}, end - index
+}
+
+// tableRow parses the line index into a row of the given columns.
+func (p *parser) tableRow(index int, aligns []string) *Token {
+	row := newToken(TableRow, "")
+	row.Pos = p.span(index, countSpaces(p.lines[index]), index)
+
+	cells := splitRow(p, index)
+	for i, align := range aligns {
+		token := newToken(TableCell, "")
+		if align != "" {
+			token.Attrs["align"] = align
+		}
+		if i < len(cells) {
+			token.Pos = cells[i].pos
+			p.inline(token, cells[i].text, cells[i].m)
+		} else {
+			token.Pos = Position{row.Pos.End, row.Pos.End}
+		}
+		row.Children = append(row.Children, token)
+	}
+	return row
+}
@@ -5,65 +5,44 @@ const (
 	UnorderedListItem           = "UnorderedListItem"
 )
 
-func parseUnorderedList(lines []string, index int) ([]*Token, int) {
-	if isEmpty(lines[index]) {
-		return nil, 0
+// bulletMarker parses the marker of an unordered item, `-`, `+` or `*`
+// followed by a space, a tab or the end of the line. It returns the marker and the column where the
+// item content starts, 0 when line doesn't start an item.
+func bulletMarker(line string) (byte, int) {
+	spaces := countSpaces(line)
+	if spaces > 3 || spaces >= len(line) {
+		return 0, 0
 	}
-
-	isList := func(line string) bool {
-		return line == "- " || line == "+ " || line == "* "
+	bullet := line[spaces]
+	if bullet != '-' && bullet != '+' && bullet != '*' {
+		return 0, 0
 	}
+	if spaces+1 < len(line) && line[spaces+1] != ' ' && line[spaces+1] != '\t' {
+		return 0, 0
+	}
+	return bullet, contentColumn(line, spaces+1)
+}
 
-	skip := 0
-	list := newToken(UnorderedList, "")
-	current := list
-	for index < len(lines) {
-		if isEmpty(lines[index]) {
-			break
-		}
-
-		spaces := countSpaces(lines[index])
-
-		subI := spaces / 2
-
-		firstChars := lines[index][spaces : spaces+2]
-
-		if !isList(firstChars) && spaces == 0 {
-			break
-		}
-
-		for subI > 0 {
-			if current != nil && len(current.Children) > 0 {
-				current = current.Children[len(current.Children)-1]
-			}
-			subI--
-		}
-
-		inc_value := 1
-		skip_value := 1
+// isList reports whether line starts an item.
+func isList(line string) bool {
+	_, col := bulletMarker(line)
+	return col > 0
+}
 
-		if tokens, skip_bloc := parseCodeBlockWithSpaces(lines, index, spaces); tokens != nil {
-			current.Children = append(current.Children, tokens...)
-			inc_value = skip_bloc
-			skip_value = skip_bloc
-		} else {
-			if isList(firstChars) {
-				current.Children = append(
-					current.Children,
-					newToken(UnorderedListItem, lines[index][spaces+2:]),
-				)
-			} else {
-				current.Children = append(
-					current.Children,
-					newToken(Paragraph, lines[index][spaces:]),
-				)
-			}
-		}
+func unorderedItemMarker(line string) (byte, int, Attribute) {
+	bullet, col := bulletMarker(line)
+	return bullet, col, Attribute{}
+}
 
-		current = list
-		skip += skip_value
-		index += inc_value
+// parseUnorderedList parses consecutive items using the same bullet, which is
+// the `bullet` attribute of the list.
+func parseUnorderedList(p *parser, index int) ([]*Token, int) {
+	bullet, col := bulletMarker(p.lines[index])
+	if col == 0 {
+		return nil, 0
 	}
 
+	list, skip := parseList(p, index, UnorderedList, UnorderedListItem, unorderedItemMarker)
+	list.Attrs["bullet"] = string(bullet)
 	return []*Token{list}, skip
 }
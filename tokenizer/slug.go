@@ -0,0 +1,124 @@
+package tokenizer
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// SlugStyle is the way heading ids are made from their text, following the
+// anchors of a Markdown host.
+type SlugStyle int
+
+const (
+	// SlugGitHub lowercases the text, drops punctuation and turns every
+	// space into a hyphen.
+	SlugGitHub SlugStyle = iota
+	// SlugGitLab is SlugGitHub with runs of hyphens merged.
+	SlugGitLab
+	// SlugPandoc also keeps periods, joins the words with hyphens and starts
+	// at the first letter, `section` standing for an empty id.
+	SlugPandoc
+)
+
+// slugStyles maps the names used on the command line to slug styles.
+var slugStyles = map[string]SlugStyle{
+	"github": SlugGitHub,
+	"gitlab": SlugGitLab,
+	"pandoc": SlugPandoc,
+}
+
+// ParseSlugStyle returns the slug style with the given name: `github`,
+// `gitlab` or `pandoc`.
+func ParseSlugStyle(name string) (SlugStyle, error) {
+	style, found := slugStyles[strings.ToLower(strings.TrimSpace(name))]
+	if !found {
+		return SlugGitHub, fmt.Errorf("unknown slug style %q", name)
+	}
+	return style, nil
+}
+
+// Slug returns the id of a heading with the given text.
+func Slug(text string, style SlugStyle) string {
+	if style == SlugGitLab || style == SlugPandoc {
+		text = strings.TrimSpace(text)
+	}
+
+	var b strings.Builder
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsMark(r) || r == '_' || r == '-':
+			b.WriteRune(r)
+		case r == '.' && style == SlugPandoc:
+			b.WriteRune(r)
+		case unicode.IsSpace(r):
+			b.WriteByte(' ')
+		}
+	}
+	slug := b.String()
+
+	switch style {
+	case SlugGitLab:
+		slug = strings.ReplaceAll(slug, " ", "-")
+		for strings.Contains(slug, "--") {
+			slug = strings.ReplaceAll(slug, "--", "-")
+		}
+	case SlugPandoc:
+		slug = strings.Join(strings.Fields(slug), "-")
+		slug = strings.TrimLeftFunc(slug, func(r rune) bool { return !unicode.IsLetter(r) })
+		if slug == "" {
+			slug = "section"
+		}
+	default:
+		slug = strings.ReplaceAll(slug, " ", "-")
+	}
+	return slug
+}
+
+// WithSlugStyle sets how the ids of the headings are made, SlugGitHub by
+// default.
+func (p *parser) WithSlugStyle(style SlugStyle) *parser {
+	p.slugStyle = style
+	return p
+}
+
+// PlainText returns the text of inline tokens without their markup, breaks
+// becoming spaces. Images and footnote references are left out.
+func PlainText(tokens []*Token) string {
+	var b strings.Builder
+	for _, token := range tokens {
+		switch token.Ttype {
+		case Text, CodeSpan:
+			b.WriteString(token.Value)
+		case SoftBreak, HardBreak:
+			b.WriteByte(' ')
+		case Image, FootnoteRef:
+		default:
+			b.WriteString(PlainText(token.Children))
+		}
+	}
+	return b.String()
+}
+
+// identify sets the `id` attribute of the headings in tokens to the slug of
+// their text, a number being appended to the ids already used in the
+// document: `intro`, `intro-1`. Headings whose slug is empty get no id.
+func (d *document) identify(tokens []*Token, style SlugStyle) {
+	for _, token := range tokens {
+		if HeadingLevel(token) == 0 {
+			d.identify(token.Children, style)
+			continue
+		}
+
+		slug := Slug(PlainText(token.Children), style)
+		if slug == "" {
+			continue
+		}
+		id := slug
+		for n := 1; d.ids[id]; n++ {
+			id = fmt.Sprintf("%s-%d", slug, n)
+		}
+		d.ids[id] = true
+		token.Attrs["id"] = id
+	}
+}
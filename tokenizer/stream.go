@@ -0,0 +1,158 @@
+package tokenizer
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// streamParser tokenizes a document read from an io.Reader, handing out the
+// top-level blocks as soon as they are complete.
+type streamParser struct {
+	reader     *bufio.Reader
+	extensions Extensions
+	slugStyle  SlugStyle
+	// doc holds the definitions read so far and the numbering of the
+	// footnotes referred to by the blocks handed out.
+	doc *document
+	// meta is the metadata of the front matter.
+	meta map[string]any
+}
+
+// NewStreamParser returns a parser reading its content from r. Only the lines
+// of the block being built are kept in memory, up to twice as many while
+// waiting for the next block to start.
+func NewStreamParser(r io.Reader) *streamParser {
+	return &streamParser{reader: bufio.NewReader(r), doc: newDocument()}
+}
+
+// WithExtensions enables the given optional syntax features.
+func (s *streamParser) WithExtensions(ext Extensions) *streamParser {
+	s.extensions = ext
+	return s
+}
+
+// WithSlugStyle sets how the ids of the headings are made.
+func (s *streamParser) WithSlugStyle(style SlugStyle) *streamParser {
+	s.slugStyle = style
+	return s
+}
+
+// Stream reads the content line by line and calls fn with every top-level
+// block once it's complete, which is known when the next block starts. The
+// blocks are the ones Tokenize returns for the whole content, except that a
+// reference link or a footnote reference can only use a definition found
+// before the block is handed out. The front matter is left out, its metadata
+// being returned by Meta. Stream stops at the first error returned by the
+// reader or fn.
+func (s *streamParser) Stream(fn func(*Token) error) error {
+	lines := []string{}
+	origins := []Point{}
+	next := Point{Line: 1, Column: 1}
+	// attempt is the number of buffered lines that didn't complete a block,
+	// parsing again is delayed until it doubles so that long blocks aren't
+	// parsed once per line.
+	attempt := 1
+	// frontMatter is set until the front matter, if any, is read.
+	frontMatter := true
+
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		eof := err == io.EOF
+
+		line = strings.TrimSuffix(line, "\n")
+		lines = append(lines, line)
+		origins = append(origins, next)
+		next = Point{Line: next.Line + 1, Column: 1, Offset: next.Offset + len(line) + 1}
+
+		if frontMatter {
+			if !eof && frontMatterOpen(lines) {
+				continue
+			}
+			frontMatter = false
+			meta, end, _ := parseFrontMatter(lines)
+			s.meta = meta
+			lines, origins = lines[end:], origins[end:]
+			if len(lines) == 0 {
+				if eof {
+					return nil
+				}
+				continue
+			}
+		}
+
+		if eof {
+			tokens := s.tokenize(lines, origins)
+			s.doc.numberFootnotes(tokens)
+			if footnotes := s.doc.footnoteSection(origins[len(origins)-1].advance(len(line))); footnotes != nil {
+				tokens = append(tokens, footnotes)
+			}
+			s.doc.identify(tokens, s.slugStyle)
+			for _, token := range tokens {
+				if err := fn(token); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		if len(lines) < 2*attempt {
+			continue
+		}
+
+		tokens := s.tokenize(lines, origins)
+		if len(tokens) < 2 {
+			attempt = len(lines)
+			continue
+		}
+
+		last := tokens[len(tokens)-1]
+		for _, token := range tokens[:len(tokens)-1] {
+			s.doc.numberFootnotes([]*Token{token})
+			s.doc.identify([]*Token{token}, s.slugStyle)
+			if err := fn(token); err != nil {
+				return err
+			}
+		}
+
+		// Keep the lines of the last block, it may continue on the next ones.
+		keep := last.Pos.Start.Line - origins[0].Line
+		lines = append([]string{}, lines[keep:]...)
+		origins = append([]Point{}, origins[keep:]...)
+		attempt = 1
+	}
+}
+
+// tokenize parses the buffered lines, recording the definitions they contain
+// for the next blocks.
+func (s *streamParser) tokenize(lines []string, origins []Point) []*Token {
+	p := newParser(lines, origins).WithExtensions(s.extensions)
+	s.doc.diagnostics = nil
+	p.doc = s.doc
+	return p.tokenize(0)
+}
+
+// Meta returns the metadata of the front matter starting the content, nil
+// until Stream has read it or when there's none.
+func (s *streamParser) Meta() map[string]any {
+	return s.meta
+}
+
+// Blocks is like Stream, sending the blocks over the returned channel, which
+// must be drained. The channel is closed once the content is read, the error
+// channel then receiving the error that stopped the reading, or nil.
+func (s *streamParser) Blocks() (<-chan *Token, <-chan error) {
+	blocks := make(chan *Token)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Stream(func(token *Token) error {
+			blocks <- token
+			return nil
+		})
+		close(blocks)
+	}()
+	return blocks, errs
+}
@@ -0,0 +1,62 @@
+package tokenizer
+
+import "fmt"
+
+// taskMarker parses the checkbox starting the content of a task list item,
+// `[ ]` or `[x]` followed by a space or a tab. It returns whether the box is
+// checked and the width of the box with the space, 0 when content isn't a task.
+func taskMarker(content string) (bool, int) {
+	if len(content) < 4 || content[0] != '[' || content[2] != ']' {
+		return false, 0
+	}
+	if content[3] != ' ' && content[3] != '\t' {
+		return false, 0
+	}
+	switch content[1] {
+	case ' ':
+		return false, 4
+	case 'x', 'X':
+		return true, 4
+	}
+	return false, 0
+}
+
+// ToggleTask checks or unchecks the task list item found on line of source,
+// counted from 1. It returns source with only the checkbox rewritten.
+func ToggleTask(source string, line int) (string, error) {
+	item := findTask(NewParser(source).Tokenize(), line)
+	if item == nil {
+		return "", fmt.Errorf("no task list item on line %d", line)
+	}
+
+	// The box follows the marker of the item and its spaces.
+	i := item.Pos.Start.Offset
+	for source[i] != ' ' && source[i] != '\t' {
+		i++
+	}
+	for source[i] == ' ' || source[i] == '\t' {
+		i++
+	}
+
+	box := " "
+	if checked, _ := item.Attrs["checked"].(bool); !checked {
+		box = "x"
+	}
+	return source[:i+1] + box + source[i+2:], nil
+}
+
+// findTask returns the outermost task list item starting on line.
+func findTask(tokens []*Token, line int) *Token {
+	for _, token := range tokens {
+		if token.Pos.Start.Line > line || token.Pos.End.Line < line {
+			continue
+		}
+		if _, ok := token.Attrs["checked"]; ok && token.Pos.Start.Line == line {
+			return token
+		}
+		if item := findTask(token.Children, line); item != nil {
+			return item
+		}
+	}
+	return nil
+}
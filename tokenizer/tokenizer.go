@@ -1,26 +1,95 @@
 package tokenizer
 
 import (
+	"sort"
 	"strings"
 )
 
 type TokenType string
 
-const (
-	Paragraph TokenType = "Paragraph"
-)
-
 type Attribute map[string]any
 
+// Point is a location in the source. Columns count bytes.
+type Point struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	Offset int `json:"offset"`
+}
+
+// Position is the range of the source a token was parsed from, End pointing
+// right after its last byte.
+type Position struct {
+	Start Point `json:"start"`
+	End   Point `json:"end"`
+}
+
+// advance returns the point n bytes further on the same line.
+func (pt Point) advance(n int) Point {
+	return Point{Line: pt.Line, Column: pt.Column + n, Offset: pt.Offset + n}
+}
+
+// sourceMap locates the bytes of a text assembled from pieces of several
+// lines: segments are sorted by the offset in the text where they start.
+type sourceMap []segment
+
+type segment struct {
+	offset int
+	point  Point
+}
+
+// at returns the source location of the byte at offset in the text.
+func (m sourceMap) at(offset int) Point {
+	i := m.segment(offset)
+	return m[i].point.advance(offset - m[i].offset)
+}
+
+// segment returns the index of the segment holding the byte at offset, the
+// last one starting at or before it.
+func (m sourceMap) segment(offset int) int {
+	i := sort.Search(len(m), func(i int) bool { return m[i].offset > offset })
+	if i == 0 {
+		return 0
+	}
+	return i - 1
+}
+
+// slice returns the map of the text from offset n to offset end.
+func (m sourceMap) slice(n int, end int) sourceMap {
+	i, last := m.segment(n), m.segment(end)
+	sliced := make(sourceMap, 0, last-i+1)
+	sliced = append(sliced, segment{0, m[i].point.advance(n - m[i].offset)})
+	for _, s := range m[i+1 : last+1] {
+		sliced = append(sliced, segment{s.offset - n, s.point})
+	}
+	return sliced
+}
+
 type Token struct {
 	Ttype    TokenType `json:"type"`
 	Value    string    `json:"value"`
 	Children []*Token  `json:"children"`
 	Attrs    Attribute `json:"attributes"`
+	Pos      Position  `json:"position"`
+	// source locates the bytes of the value of a text token holding decoded
+	// escapes or entities, nil when the value is a copy of the source.
+	source sourceMap
+}
+
+// at returns the source location of the byte at offset in the value of a
+// text token.
+func (t *Token) at(offset int) Point {
+	if t.source == nil {
+		return t.Pos.Start.advance(offset)
+	}
+	return t.source.at(offset)
 }
 
-type Renderable interface {
-	Render()
+// sourceOf returns the map locating the bytes of the value of a text token.
+func (t *Token) sourceOf() sourceMap {
+	if t.source == nil {
+		return sourceMap{{0, t.Pos.Start}}
+	}
+	return t.source
 }
 
 func newToken(ttype TokenType, value string) *Token {
@@ -38,51 +107,202 @@ func isEmpty(line string) bool {
 
 func countSpaces(line string) int {
 	spaces := 0
-	for line[spaces] == ' ' {
+	for spaces < len(line) && line[spaces] == ' ' {
 		spaces++
 	}
 	return spaces
 }
 
-type ParserFunc func([]string, int) ([]*Token, int)
+// ParserFunc parses the block starting at the line index, it returns the
+// parsed tokens and the number of lines consumed, 0 when it doesn't apply.
+type ParserFunc func(*parser, int) ([]*Token, int)
 
 type parser struct {
 	lines   []string
-	parsers []ParserFunc
+	origins []Point
+	// lazy tells the lines lazily continuing a paragraph of the container
+	// holding them, which can't be anything but paragraph text.
+	lazy       []bool
+	parsers    []ParserFunc
+	extensions Extensions
+	slugStyle  SlugStyle
+	safe       bool
+	doc        *document
+}
+
+// document is the state shared by the parser of a document and the parsers of
+// the blocks nested in it.
+type document struct {
+	references  map[string]Reference
+	diagnostics []Diagnostic
+	// inlines parse the inline content of the blocks once the whole document,
+	// and so every link reference definition, has been read.
+	inlines []func()
+	// footnotes are the footnote definitions by normalized label, numbered
+	// in footnoteOrder, with the count of references to each.
+	footnotes     map[string]*Token
+	footnoteOrder []string
+	footnoteRefs  map[string]int
+	// ids are the heading ids given so far.
+	ids map[string]bool
+}
+
+func newDocument() *document {
+	return &document{
+		references:   map[string]Reference{},
+		footnotes:    map[string]*Token{},
+		footnoteRefs: map[string]int{},
+		ids:          map[string]bool{},
+	}
+}
+
+// Document is a parsed document: its blocks and what is defined for the whole
+// of it.
+type Document struct {
+	Tokens []*Token `json:"tokens"`
+	// References are the link reference definitions, by normalized label.
+	References map[string]Reference `json:"references"`
+	// Meta is the metadata of the front matter, nil without front matter.
+	Meta map[string]any `json:"meta,omitempty"`
+	// FrontMatter is the source of the front matter, delimiters included.
+	FrontMatter string `json:"frontMatter,omitempty"`
 }
 
 func NewParser(content string) *parser {
+	lines := strings.Split(content, "\n")
+	origins := make([]Point, len(lines))
+	offset := 0
+	for i, line := range lines {
+		origins[i] = Point{Line: i + 1, Column: 1, Offset: offset}
+		offset += len(line) + 1
+	}
+	return newParser(lines, origins)
+}
+
+// newParser returns a parser for lines found at origins in the source.
+func newParser(lines []string, origins []Point) *parser {
 	return &parser{
-		lines: strings.Split(content, "\n"),
+		lines:   lines,
+		origins: origins,
 		parsers: []ParserFunc{
+			parseIndentedCode,
 			parseHr,
 			parseHeading,
 			parseCodeBlock,
+			parseHTMLBlock,
 			parseBlockquote,
 			parseUnorderedList,
 			parseOrderedList,
+			parseTable,
+			parseFootnote,
+			parseDefinition,
 			parseParagraph,
 		},
+		doc: newDocument(),
 	}
 }
 
-func parseParagraph(lines []string, index int) ([]*Token, int) {
-	if isEmpty(lines[index]) {
-		return nil, 0
-	}
-	paragraph := newToken(Paragraph, "")
-	paragraph.Children = parseSpans(lines[index])
-	return []*Token{paragraph}, 1
+// WithExtensions enables the given optional syntax features.
+func (p *parser) WithExtensions(ext Extensions) *parser {
+	p.extensions = ext
+	return p
+}
+
+// point returns the source location of the byte at column col of a line.
+func (p *parser) point(line int, col int) Point {
+	return p.origins[line].advance(col)
+}
+
+// isLazy reports whether the line index lazily continues a paragraph.
+func (p *parser) isLazy(index int) bool {
+	return p.lazy != nil && p.lazy[index]
+}
+
+// span returns the position from column col of the line start to the end of
+// the line end.
+func (p *parser) span(start int, col int, end int) Position {
+	return Position{p.point(start, col), p.point(end, len(p.lines[end]))}
+}
+
+// inline parses text, m locating it in the source, into the children of
+// token once the whole document has been read.
+func (p *parser) inline(token *Token, text string, m sourceMap) {
+	p.doc.inlines = append(p.doc.inlines, func() {
+		if p.safe {
+			defer p.recoverInline(m.at(0))
+		}
+		token.Children = parseSpansAt(p, text, m)
+	})
+}
+
+// parseNested tokenizes lines nested in a block, such as the content of a
+// blockquote, found at origins in the source, lazy telling those lazily
+// continuing a paragraph. They are part of the document of p.
+func (p *parser) parseNested(lines []string, origins []Point, lazy []bool) []*Token {
+	nested := newParser(lines, origins)
+	nested.lazy = lazy
+	nested.parsers = p.parsers
+	nested.extensions = p.extensions
+	nested.safe = p.safe
+	nested.doc = p.doc
+	return nested.blocks(0)
 }
 
 func (p *parser) Tokenize() []*Token {
-	i := 0
+	return p.Parse().Tokens
+}
+
+// Parse tokenizes the content into a Document. The front matter starting the
+// content is left out of the tokens, its metadata being parsed into the Meta
+// of the document and its source kept in FrontMatter. The inline content is parsed last, links and footnote
+// references referring to definitions found anywhere in the document. The
+// footnotes referred to are added at the end. Headings get an `id` made from
+// their text.
+func (p *parser) Parse() *Document {
+	meta, start := p.frontMatter()
+	tokens := p.tokenize(start)
+	p.doc.footnoteOrder, p.doc.footnoteRefs = nil, map[string]int{}
+	p.doc.numberFootnotes(tokens)
+	last := len(p.lines) - 1
+	if footnotes := p.doc.footnoteSection(p.point(last, len(p.lines[last]))); footnotes != nil {
+		tokens = append(tokens, footnotes)
+	}
+	p.doc.ids = map[string]bool{}
+	p.doc.identify(tokens, p.slugStyle)
+	doc := &Document{Tokens: tokens, References: p.doc.references, Meta: meta}
+	if start > 0 {
+		doc.FrontMatter = strings.Join(p.lines[:start], "\n")
+	}
+	return doc
+}
+
+// tokenize parses the blocks from the line start, then their inline content.
+func (p *parser) tokenize(start int) []*Token {
+	tokens := p.blocks(start)
+	inlines := p.doc.inlines
+	p.doc.inlines = nil
+	for _, inline := range inlines {
+		inline()
+	}
+	return tokens
+}
+
+// blocks parses the lines from the line start into blocks, leaving their
+// inline content for later.
+func (p *parser) blocks(start int) []*Token {
+	i := start
 	tokens := []*Token{}
 
 out:
 	for i < len(p.lines) {
 		for _, parser := range p.parsers {
-			blocks, skip := parser(p.lines, i)
+			var blocks []*Token
+			var skip int
+			if p.safe {
+				blocks, skip = p.parseSafely(parser, i)
+			} else {
+				blocks, skip = parser(p, i)
+			}
 			if skip > 0 {
 				tokens = append(tokens, blocks...)
 				i += skip
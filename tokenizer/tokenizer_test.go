@@ -1,6 +1,11 @@
 package tokenizer
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
 	"testing"
 )
 
@@ -110,15 +115,89 @@ func TestCodeBlockWithLanguage(t *testing.T) {
 	}
 }
 
+func TestFencedCodeBlocks(t *testing.T) {
+	cases := map[string][2]string{
+		"```\nno language\n```":                     {"no language", ""},
+		"~~~ python\ndef f():\n    return 1\n~~~":   {"def f():\n    return 1", "python"},
+		"````md\n```go\ncode\n```\n````":            {"```go\ncode\n```", "md"},
+		"~~~\n```\n~~\n~~~~~  ":                     {"```\n~~", ""},
+		"  ```\n  a\n    b\n c\n   ```":             {"a\n  b\nc", ""},
+		"```yaml\nkey:\n  - item\n\n  - other\n```": {"key:\n  - item\n\n  - other", "yaml"},
+		"```\n``` not closing\n```":                 {"``` not closing", ""},
+	}
+	for content, expected := range cases {
+		tokens := NewParser(content).Tokenize()
+		if len(tokens) != 1 || !tokenValid(tokens[0], CodeBloc, expected[0]) || tokens[0].Attrs["language"] != expected[1] {
+			t.Errorf("%q: unexpected code block %s %q", content, tree(tokens), tokens[0].Value)
+		}
+	}
+
+	for _, content := range []string{"``\ncode\n``", "``` a`b\ncode", "    ```\ncode\n    ```"} {
+		if tokens := NewParser(content).Tokenize(); tokens[0].Ttype == CodeBloc && tokens[0].Value == "code" {
+			t.Errorf("%q should not be a fenced code block", content)
+		}
+	}
+
+	tokens, diagnostics := NewParser("text\n~~~\nunclosed\n\n```").TokenizeWithDiagnostics()
+	if len(tokens) != 2 || tokens[1].Value != "unclosed\n\n```" || len(diagnostics) != 1 {
+		t.Errorf("Unclosed code block should run to the end %s %v", tree(tokens), diagnostics)
+	}
+}
+
+func TestIndentedCodeBlocks(t *testing.T) {
+	tokens := NewParser("    if a {\n\n\t\tb()\n      }\n\n\ntext\n    more text").Tokenize()
+	if len(tokens) != 2 || !tokenValid(tokens[0], CodeBloc, "if a {\n\n\tb()\n  }") {
+		t.Errorf("Unexpected tokens %s %q", tree(tokens), tokens[0].Value)
+	}
+	if end := tokens[0].Pos.End; end.Line != 4 || end.Column != 8 {
+		t.Errorf("Code block should end with its last line %v", end)
+	}
+	if tree(tokens[1:]) != `Paragraph("text", SoftBreak(), "more text")` {
+		t.Errorf("Indented code should not interrupt a paragraph %s", tree(tokens[1:]))
+	}
+
+	tokens = NewParser("- item\n\n      code").Tokenize()
+	if item := tokens[0].Children[0]; len(item.Children) != 2 || !tokenValid(item.Children[1], CodeBloc, "code") {
+		t.Errorf("Expected code in the item %s", tree(tokens))
+	}
+}
+
+func TestInfoString(t *testing.T) {
+	cases := map[string]string{
+		"go":                                   "go map[]",
+		"go linenos":                           "go map[]",
+		"{.go linenos=true title=\"main.go\"}": "go map[linenos:true title:main.go]",
+		"go {#ex .numbered hl='1 3' wrap}":     "go map[class:numbered hl:1 3 id:ex wrap:]",
+		"{#ex .a .b}":                          "a map[class:b id:ex]",
+		"{title=\"no language\"}":              " map[title:no language]",
+		"{.unclosed":                           "{.unclosed map[]",
+	}
+	for info, expected := range cases {
+		language, attributes := infoString(info)
+		if result := fmt.Sprint(language, " ", attributes); result != expected {
+			t.Errorf("%q: expected %s, got %s", info, expected, result)
+		}
+	}
+
+	tokens := NewParser("```{.go title=\"main.go\"}\ncode\n```").Tokenize()
+	attributes, _ := tokens[0].Attrs["attributes"].(map[string]string)
+	if tokens[0].Attrs["language"] != "go" || attributes["title"] != "main.go" || tokens[0].Attrs["info"] != "{.go title=\"main.go\"}" {
+		t.Errorf("Unexpected attributes %v", tokens[0].Attrs)
+	}
+}
+
 func TestBlocquote(t *testing.T) {
 	tokens := NewParser("> Hello world").Tokenize()
 
 	if len(tokens) < 1 {
 		t.Error("Not enough tokens")
 	}
-	if !tokenValid(tokens[0], Blockquote, "Hello world") {
+	if !tokenValid(tokens[0], Blockquote, "") {
 		t.Error("Not valid Blockquote")
 	}
+	if result := tree(tokens); result != `Blockquote(Paragraph("Hello world"))` {
+		t.Errorf("Blockquote parsed as %s", result)
+	}
 }
 
 func TestBlocquoteMultipleLines(t *testing.T) {
@@ -128,9 +207,78 @@ func TestBlocquoteMultipleLines(t *testing.T) {
 		t.Error("Not enough tokens")
 	}
 
-	if !tokenValid(tokens[0], Blockquote, "Hello world") {
+	if !tokenValid(tokens[0], Blockquote, "") {
 		t.Error("Not valid Blockquote")
 	}
+	expected := `Blockquote(Paragraph("Hello world", SoftBreak(), "Something else"))`
+	if result := tree(tokens); result != expected {
+		t.Errorf("Blockquote parsed as %s", result)
+	}
+}
+
+func TestBlockquoteContent(t *testing.T) {
+	cases := map[string]string{
+		"> # Title\n>\n> - item\n>\n> text": `Blockquote(Heading1("Title"), UnorderedList(UnorderedListItem(Paragraph("item"))), Paragraph("text"))`,
+		"> outer\n>> inner":                 `Blockquote(Paragraph("outer"), Blockquote(Paragraph("inner")))`,
+		">no space\n>":                      `Blockquote(Paragraph("no space"))`,
+		"> lazy\ncontinuation":              `Blockquote(Paragraph("lazy", SoftBreak(), "continuation"))`,
+		"> > nested lazy\ncontinuation":     `Blockquote(Blockquote(Paragraph("nested lazy", SoftBreak(), "continuation")))`,
+		"> # Title\nafter":                  `Blockquote(Heading1("Title")), Paragraph("after")`,
+		"> quote\n\n> another":              `Blockquote(Paragraph("quote")), Blockquote(Paragraph("another"))`,
+		"> quote\n- item":                   `Blockquote(Paragraph("quote")), UnorderedList(UnorderedListItem(Paragraph("item")))`,
+		"> ```go\n> code\n> ```":            `Blockquote(CodeBloc())`,
+	}
+
+	for content, expected := range cases {
+		if result := tree(NewParser(content).Tokenize()); result != expected {
+			t.Errorf("%q: expected %s, got %s", content, expected, result)
+		}
+	}
+}
+
+func TestLazyUnderline(t *testing.T) {
+	cases := map[string]string{
+		"> foo\nbar\n===": `Blockquote(Paragraph("foo", SoftBreak(), "bar", SoftBreak(), "==="))`,
+		"> foo\n===":      `Blockquote(Paragraph("foo", SoftBreak(), "==="))`,
+		"> foo\n> ===":    `Blockquote(Heading1("foo"))`,
+		"- a\n===":        `UnorderedList(UnorderedListItem(Paragraph("a", SoftBreak(), "===")))`,
+		"- a\n  ===":      `UnorderedList(UnorderedListItem(Heading1("a")))`,
+		"> - a\n  ===":    `Blockquote(UnorderedList(UnorderedListItem(Paragraph("a", SoftBreak(), "==="))))`,
+	}
+
+	for content, expected := range cases {
+		if result := tree(NewParser(content).Tokenize()); result != expected {
+			t.Errorf("%q: expected %s, got %s", content, expected, result)
+		}
+	}
+}
+
+func TestBlockquotePositions(t *testing.T) {
+	tokens := NewParser("> a\n>> *b*").Tokenize()
+	quote := tokens[0]
+	if quote.Pos != positionOf(1, 1, 0, 2, 7, 10) {
+		t.Errorf("Blockquote at %+v", quote.Pos)
+	}
+	nested := quote.Children[1]
+	if nested.Pos != positionOf(2, 2, 5, 2, 7, 10) {
+		t.Errorf("Nested blockquote at %+v", nested.Pos)
+	}
+	if emphasis := nested.Children[0].Children[0]; emphasis.Pos != positionOf(2, 4, 7, 2, 7, 10) {
+		t.Errorf("Emphasis at %+v", emphasis.Pos)
+	}
+}
+
+// text returns the text of the first paragraph of a list item, soft breaks
+// replaced with spaces.
+func text(item *Token) string {
+	parts := []string{}
+	for _, span := range item.Children[0].Children {
+		if span.Ttype == SoftBreak {
+			parts = append(parts, " ")
+		}
+		parts = append(parts, span.Value)
+	}
+	return strings.Join(parts, "")
 }
 
 func TestUnorederList(t *testing.T) {
@@ -148,7 +296,7 @@ func TestUnorederList(t *testing.T) {
 		t.Error("Should parse 4 items.")
 	}
 
-	if tokens[0].Children[2].Value != "Third item" {
+	if text(tokens[0].Children[2]) != "Third item" {
 		t.Error("Third item is not valid.")
 	}
 }
@@ -168,7 +316,7 @@ func TestNestedUnorederList(t *testing.T) {
 	if !tokenValid(tokens[0], UnorderedList, "") {
 		t.Error("Not valid UnorderedList")
 	}
-	value := tokens[0].Children[2].Children[0].Value
+	value := text(tokens[0].Children[2].Children[1].Children[0])
 	if value != "Indented item" {
 		t.Errorf("Not valid indented unordered list item. `%s`", value)
 	}
@@ -185,15 +333,15 @@ func TestSimpleCodeBlocWithinUnorderedList(t *testing.T) {
 		t.Error("Not valid UnorderedList")
 	}
 
-	if tokens[0].Children[0].Value != "Winter" {
+	if text(tokens[0].Children[0]) != "Winter" {
 		t.Error("Not valid UnorderedListItem Winter")
 	}
 
-	if tokens[0].Children[0].Children[0].Ttype != CodeBloc {
+	if tokens[0].Children[0].Children[1].Ttype != CodeBloc {
 		t.Error("Not valid CodeBloc")
 	}
 
-	if tokens[0].Children[1].Value != "Frost" {
+	if text(tokens[0].Children[1]) != "Frost" {
 		t.Error("Not valid UnorderedListItem Frost")
 	}
 }
@@ -207,10 +355,10 @@ func TestParagraphWithinUnorderedList(t *testing.T) {
 	if !tokenValid(tokens[0], UnorderedList, "") {
 		t.Error("Not valid UnorderedList")
 	}
-	if tokens[0].Children[0].Value != "Winter" {
+	if text(tokens[0].Children[0]) != "Winter" {
 		t.Error("Not valid UnorderedListItem Winter")
 	}
-	if tokens[0].Children[1].Value != "Frost" {
+	if text(tokens[0].Children[1]) != "Frost hello" {
 		t.Error("Not valid UnorderedListItem Frost")
 	}
 	if tokens[0].Children[1].Children[0].Ttype != Paragraph {
@@ -218,6 +366,140 @@ func TestParagraphWithinUnorderedList(t *testing.T) {
 	}
 }
 
+func TestUnorderedListItems(t *testing.T) {
+	cases := map[string]string{
+		"- *a* [b](c)":             `UnorderedList(UnorderedListItem(Paragraph(Emphasis("a"), " ", Link("b"))))`,
+		"- a\n\n  b\n- c":          `UnorderedList(UnorderedListItem(Paragraph("a"), Paragraph("b")), UnorderedListItem(Paragraph("c")))`,
+		"- a\n  - b\n\n    c\n- d": `UnorderedList(UnorderedListItem(Paragraph("a"), UnorderedList(UnorderedListItem(Paragraph("b"), Paragraph("c")))), UnorderedListItem(Paragraph("d")))`,
+		"-   a\n    b\n   c":       `UnorderedList(UnorderedListItem(Paragraph("a", SoftBreak(), "b", SoftBreak(), "c")))`,
+		"- a\n - b\n   - c":        `UnorderedList(UnorderedListItem(Paragraph("a")), UnorderedListItem(Paragraph("b"), UnorderedList(UnorderedListItem(Paragraph("c")))))`,
+		"-\ta\n\t- b":              `UnorderedList(UnorderedListItem(Paragraph("a"), UnorderedList(UnorderedListItem(Paragraph("b")))))`,
+		"- a\n\tb":                 `UnorderedList(UnorderedListItem(Paragraph("a", SoftBreak(), "b")))`,
+		"- a\n+ b":                 `UnorderedList(UnorderedListItem(Paragraph("a"))), UnorderedList(UnorderedListItem(Paragraph("b")))`,
+		"- a\n\nb":                 `UnorderedList(UnorderedListItem(Paragraph("a"))), Paragraph("b")`,
+		"- \n\n  a":                `UnorderedList(UnorderedListItem()), Paragraph("a")`,
+		"-a":                       `Paragraph("-a")`,
+	}
+
+	for content, expected := range cases {
+		if result := tree(NewParser(content).Tokenize()); result != expected {
+			t.Errorf("%q: expected %s, got %s", content, expected, result)
+		}
+	}
+}
+
+func TestTightLists(t *testing.T) {
+	cases := map[string]bool{
+		"- a\n- b":                   true,
+		"- a\n  - b\n\n  - c\n- d":   true,
+		"- ```go\n  a\n\n  b\n  ```": true,
+		"- a\n\n- b":                 false,
+		"- a\n\n  b":                 false,
+		"1. a\n2. b":                 true,
+		"1. a\n\n2. b":               false,
+	}
+
+	for content, expected := range cases {
+		list := NewParser(content).Tokenize()[0]
+		if list.Attrs["tight"] != expected {
+			t.Errorf("%q: expected tight to be %v, got %v", content, expected, list.Attrs["tight"])
+		}
+	}
+}
+
+func TestTaskListItems(t *testing.T) {
+	tokens := NewParser("- [ ] todo\n- [x] done\n- [X] Done\n- [] text\n- [x]not a task\n\n1. [x] *ordered*").Tokenize()
+	if len(tokens) != 2 {
+		t.Fatalf("Expected 2 lists, got %s", tree(tokens))
+	}
+
+	expected := []any{false, true, true, nil, nil}
+	for i, item := range tokens[0].Children {
+		if item.Attrs["checked"] != expected[i] {
+			t.Errorf("Item %d: expected checked to be %v, got %v", i, expected[i], item.Attrs["checked"])
+		}
+	}
+	if text(tokens[0].Children[0]) != "todo" || text(tokens[0].Children[3]) != "[] text" {
+		t.Errorf("Checkbox should be left out of the content %s", tree(tokens[:1]))
+	}
+
+	item := tokens[1].Children[0]
+	if item.Attrs["checked"] != true || tree(item.Children) != `Paragraph(Emphasis("ordered"))` {
+		t.Errorf("Not valid ordered task %v %s", item.Attrs, tree(item.Children))
+	}
+	if item.Children[0].Pos != positionOf(7, 8, 67, 7, 17, 76) {
+		t.Errorf("Task content at %+v", item.Children[0].Pos)
+	}
+}
+
+func TestToggleTask(t *testing.T) {
+	source := "# Todo\n\n- [ ] first\n- [x] second\n  > 3. [ ] nested\n- plain"
+	cases := map[int]string{
+		3: "# Todo\n\n- [x] first\n- [x] second\n  > 3. [ ] nested\n- plain",
+		4: "# Todo\n\n- [ ] first\n- [ ] second\n  > 3. [ ] nested\n- plain",
+		5: "# Todo\n\n- [ ] first\n- [x] second\n  > 3. [x] nested\n- plain",
+	}
+	for line, expected := range cases {
+		result, err := ToggleTask(source, line)
+		if err != nil || result != expected {
+			t.Errorf("Line %d: expected %q, got %q, %v", line, expected, result, err)
+		}
+	}
+
+	for _, line := range []int{1, 2, 6, 7} {
+		if _, err := ToggleTask(source, line); err == nil {
+			t.Errorf("Line %d has no task, expected an error", line)
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	tokens := NewParser("| Name | *Value* |\n|:-----|------:|\n| a \\| b | 1 |\n| c |\nd | 2 | extra\n\ntext").Tokenize()
+	if len(tokens) != 2 {
+		t.Fatalf("Expected a table and a paragraph, got %s", tree(tokens))
+	}
+
+	expected := `Table(TableRow(TableCell("Name"), TableCell(Emphasis("Value"))), ` +
+		`TableRow(TableCell("a | b"), TableCell("1")), TableRow(TableCell("c"), TableCell()), TableRow(TableCell("d"), TableCell("2")))`
+	if result := tree(tokens[:1]); result != expected {
+		t.Errorf("Table parsed as %s", result)
+	}
+
+	header := tokens[0].Children[0]
+	if header.Attrs["header"] != true || tokens[0].Children[1].Attrs["header"] != nil {
+		t.Error("Only the first row should be the header")
+	}
+	for _, row := range tokens[0].Children {
+		if row.Children[0].Attrs["align"] != "left" || row.Children[1].Attrs["align"] != "right" {
+			t.Errorf("Not valid alignment %v %v", row.Children[0].Attrs, row.Children[1].Attrs)
+		}
+	}
+
+	cell := tokens[0].Children[1].Children[0]
+	if cell.Pos != positionOf(3, 3, 38, 3, 9, 44) {
+		t.Errorf("Cell at %+v", cell.Pos)
+	}
+	if b := cell.Children[0].Pos; b != positionOf(3, 3, 38, 3, 9, 44) {
+		t.Errorf("Cell text at %+v", b)
+	}
+}
+
+func TestNotTable(t *testing.T) {
+	cases := map[string]string{
+		"a | b\n--- | :-: | ---": `Paragraph("a | b", SoftBreak(), "--- | :-: | ---")`,
+		"a | b\n--- | -x-":       `Paragraph("a | b", SoftBreak(), "--- | -x-")`,
+		"a\n---":                 `Heading2("a")`,
+		"text\na | b\n-|-\n":     `Paragraph("text"), Table(TableRow(TableCell("a"), TableCell("b")))`,
+		"| a |\n| :-: |\n- item": `Table(TableRow(TableCell("a"))), UnorderedList(UnorderedListItem(Paragraph("item")))`,
+	}
+
+	for content, expected := range cases {
+		if result := tree(NewParser(content).Tokenize()); result != expected {
+			t.Errorf("%q: expected %s, got %s", content, expected, result)
+		}
+	}
+}
+
 func TestOrderedList(t *testing.T) {
 	tokens := NewParser("1. First item\n2. Second item\n").Tokenize()
 
@@ -234,7 +516,7 @@ func TestOrderedList(t *testing.T) {
 		t.Errorf("Not valid item. `%+v`\n", item.Children[0])
 		return
 	}
-	item = item.Children[0]
+	item = item.Children[0].Children[0]
 	if item.Value != "First item" || item.Ttype != Text {
 		t.Errorf("Not valid item. `%+v`\n", item)
 		return
@@ -244,13 +526,81 @@ func TestOrderedList(t *testing.T) {
 		t.Errorf("Not valid item. `%+v`\n", item)
 		return
 	}
-	item = item.Children[0]
+	item = item.Children[0].Children[0]
 	if item.Value != "Second item" || item.Ttype != Text {
 		t.Errorf("Not valid item. `%+v`\n", item)
 		return
 	}
 }
 
+func TestNestedOrderedList(t *testing.T) {
+	cases := map[string]string{
+		"1. a\n   1. b\n   2. c\n2. d":     `OrderedList(OrderedListItem(Paragraph("a"), OrderedList(OrderedListItem(Paragraph("b")), OrderedListItem(Paragraph("c")))), OrderedListItem(Paragraph("d")))`,
+		"1) a\n   - b\n2) c":               `OrderedList(OrderedListItem(Paragraph("a"), UnorderedList(UnorderedListItem(Paragraph("b")))), OrderedListItem(Paragraph("c")))`,
+		"1. first\n   line\n2. lazy\nline": `OrderedList(OrderedListItem(Paragraph("first", SoftBreak(), "line")), OrderedListItem(Paragraph("lazy", SoftBreak(), "line")))`,
+		"1. a\n1) b":                       `OrderedList(OrderedListItem(Paragraph("a"))), OrderedList(OrderedListItem(Paragraph("b")))`,
+		"1. a\n  2. b":                     `OrderedList(OrderedListItem(Paragraph("a")), OrderedListItem(Paragraph("b")))`,
+		"10. a\n    > quote":               `OrderedList(OrderedListItem(Paragraph("a"), Blockquote(Paragraph("quote"))))`,
+		"1.a":                              `Paragraph("1.a")`,
+		"1234567890. a":                    `Paragraph("1234567890. a")`,
+	}
+
+	for content, expected := range cases {
+		if result := tree(NewParser(content).Tokenize()); result != expected {
+			t.Errorf("%q: expected %s, got %s", content, expected, result)
+		}
+	}
+}
+
+func TestOrderedListNumbers(t *testing.T) {
+	list := NewParser("3) three\n5) five").Tokenize()[0]
+	if list.Attrs["start"] != 3 || list.Attrs["delimiter"] != ")" {
+		t.Errorf("Not valid list attributes %v", list.Attrs)
+	}
+	if list.Children[0].Attrs["id"] != 3 || list.Children[1].Attrs["id"] != 5 {
+		t.Errorf("Items should keep their number %v %v", list.Children[0].Attrs, list.Children[1].Attrs)
+	}
+	nested := list.Children[1].Children[0]
+	if nested.Pos != positionOf(2, 4, 12, 2, 8, 16) {
+		t.Errorf("Item content at %+v", nested.Pos)
+	}
+}
+
+func TestListInterruptingParagraph(t *testing.T) {
+	cases := map[string]string{
+		"para\n- foo":          `Paragraph("para"), UnorderedList(UnorderedListItem(Paragraph("foo")))`,
+		"para\n   - foo":       `Paragraph("para"), UnorderedList(UnorderedListItem(Paragraph("foo")))`,
+		"para\n    - foo":      `Paragraph("para", SoftBreak(), "- foo")`,
+		"para\n1. one":         `Paragraph("para"), OrderedList(OrderedListItem(Paragraph("one")))`,
+		"text\n2. not a list":  `Paragraph("text", SoftBreak(), "2. not a list")`,
+		"> quote\n2. new list": `Blockquote(Paragraph("quote")), OrderedList(OrderedListItem(Paragraph("new list")))`,
+	}
+	for content, expected := range cases {
+		if result := tree(NewParser(content).Tokenize()); result != expected {
+			t.Errorf("%q: expected %s, got %s", content, expected, result)
+		}
+	}
+}
+
+func TestEmptyListItems(t *testing.T) {
+	cases := map[string]string{
+		"- foo\n-\n- bar": `UnorderedList(UnorderedListItem(Paragraph("foo")), UnorderedListItem(), UnorderedListItem(Paragraph("bar")))`,
+		"-\n  foo":        `UnorderedList(UnorderedListItem(Paragraph("foo")))`,
+		"-\n foo":         `UnorderedList(UnorderedListItem()), Paragraph("foo")`,
+		"para\n+":         `Paragraph("para", SoftBreak(), "+")`,
+		"para\n* ":        `Paragraph("para", SoftBreak(), "*")`,
+		"1. a\n2.\n3. b":  `OrderedList(OrderedListItem(Paragraph("a")), OrderedListItem(), OrderedListItem(Paragraph("b")))`,
+		"1)\n   foo":      `OrderedList(OrderedListItem(Paragraph("foo")))`,
+		"1.\tfoo":         `OrderedList(OrderedListItem(Paragraph("foo")))`,
+		"para\n1.":        `Paragraph("para", SoftBreak(), "1.")`,
+	}
+	for content, expected := range cases {
+		if result := tree(NewParser(content).Tokenize()); result != expected {
+			t.Errorf("%q: expected %s, got %s", content, expected, result)
+		}
+	}
+}
+
 func TestSpanSimpleText(t *testing.T) {
 	tokens := parseSpans("world")
 
@@ -267,7 +617,7 @@ func TestSpanSimpleText(t *testing.T) {
 
 func TestSpanSimpleTextBold(t *testing.T) {
 	tokens := parseSpans("world *hello*")
-	if len(tokens) < 2 {
+	if len(tokens) != 2 {
 		t.Error("Not enough token.")
 		return
 	}
@@ -275,11 +625,11 @@ func TestSpanSimpleTextBold(t *testing.T) {
 		t.Error("Span should be normal type of value world.")
 		return
 	}
-	if tokens[1].Ttype != Italic {
-		t.Error("Span should be italic")
+	if tokens[1].Ttype != Emphasis || len(tokens[1].Children) != 1 {
+		t.Error("Span should be emphasis")
 		return
 	}
-	if tokens[2].Ttype != Text || tokens[2].Value != "hello" {
+	if span := tokens[1].Children[0]; span.Ttype != Text || span.Value != "hello" {
 		t.Error("Span should be normal with value hello")
 		return
 	}
@@ -402,7 +752,7 @@ func TestParseTwoImgsWithSpace(t *testing.T) {
 
 func TestSpanParseMultipleSpans(t *testing.T) {
 	tokens := parseSpans("*world **hello*** ![world](https://example.com/img.jpg) [example](https://example.com)")
-	if len(tokens) < 10 {
+	if len(tokens) != 5 {
 		t.Errorf("Not enough token. %d", len(tokens))
 		return
 	}
@@ -413,23 +763,13 @@ func TestSpanParseMultipleSpans(t *testing.T) {
 	link := newToken(Link, "example")
 	link.Attrs["url"] = "https://example.com"
 	tags := []*Token{
-		newToken(Italic, ""),
-		newToken(Text, "world "),
-		newToken(Bold, ""),
-		newToken(Text, "hello"),
-		newToken(EndBold, ""),
-		newToken(EndItalic, ""),
+		newToken(Emphasis, ""),
 		newToken(Text, " "),
 		img,
 		newToken(Text, " "),
 		link,
 	}
 
-	if len(tags) != len(tokens) {
-		t.Error("Spans and values not equal.")
-		return
-	}
-
 	for i, span := range tokens {
 		if span.Ttype != tags[i].Ttype {
 			t.Errorf("Span not %#v", span)
@@ -448,4 +788,813 @@ func TestSpanParseMultipleSpans(t *testing.T) {
 			return
 		}
 	}
+
+	emphasis := tokens[0].Children
+	if len(emphasis) != 2 || !tokenValid(emphasis[0], Text, "world ") || emphasis[1].Ttype != Strong {
+		t.Errorf("Emphasis children not valid %#v", emphasis)
+		return
+	}
+	if strong := emphasis[1].Children; len(strong) != 1 || !tokenValid(strong[0], Text, "hello") {
+		t.Errorf("Strong children not valid %#v", strong)
+	}
+}
+
+// tree describes tokens as `Type(child, ...)`, text as its quoted value.
+func tree(tokens []*Token) string {
+	parts := []string{}
+	for _, token := range tokens {
+		if token.Ttype == Text {
+			parts = append(parts, fmt.Sprintf("%q", token.Value))
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s(%s)", token.Ttype, tree(token.Children)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func TestNestedSpans(t *testing.T) {
+	cases := map[string]string{
+		"**bold *italic* bold**":   `Strong("bold ", Emphasis("italic"), " bold")`,
+		"***both***":               `Emphasis(Strong("both"))`,
+		"*a **b** c*":              `Emphasis("a ", Strong("b"), " c")`,
+		"__under__ and _score_":    `Strong("under"), " and ", Emphasis("score")`,
+		"[**bold** link](url)":     `Link(Strong("bold"), " link")`,
+		"*foo**bar*":               `Emphasis("foo**bar")`,
+		"snake_case_name":          `"snake_case_name"`,
+		"2 * 3 * 4":                `"2 * 3 * 4"`,
+		"*unclosed and **unclosed": `"*unclosed and **unclosed"`,
+		"**unbalanced*":            `"*", Emphasis("unbalanced")`,
+		"*mixed_":                  `"*mixed_"`,
+	}
+
+	for line, expected := range cases {
+		if result := tree(parseSpans(line)); result != expected {
+			t.Errorf("`%s` parsed as %s, expected %s", line, result, expected)
+		}
+	}
+}
+
+func TestHeadingSpans(t *testing.T) {
+	tokens := NewParser("# Hello *world*").Tokenize()
+	if result := tree(tokens); result != `Heading1("Hello ", Emphasis("world"))` {
+		t.Errorf("Heading spans not parsed %s", result)
+	}
+}
+
+func positionOf(startLine, startCol, startOffset, endLine, endCol, endOffset int) Position {
+	return Position{
+		Start: Point{Line: startLine, Column: startCol, Offset: startOffset},
+		End:   Point{Line: endLine, Column: endCol, Offset: endOffset},
+	}
+}
+
+func TestBlockPositions(t *testing.T) {
+	tokens := NewParser("# Title\n\nSome *text*\n***\n```go\ncode\n```").Tokenize()
+	if len(tokens) != 4 {
+		t.Fatalf("Expected 4 blocks, got %d", len(tokens))
+	}
+
+	expected := []Position{
+		positionOf(1, 1, 0, 1, 8, 7),
+		positionOf(3, 1, 9, 3, 12, 20),
+		positionOf(4, 1, 21, 4, 4, 24),
+		positionOf(5, 1, 25, 7, 4, 39),
+	}
+	for i, token := range tokens {
+		if token.Pos != expected[i] {
+			t.Errorf("%s at %+v, expected %+v", token.Ttype, token.Pos, expected[i])
+		}
+	}
+}
+
+func TestSpanPositions(t *testing.T) {
+	tokens := NewParser("\nSome *text* [a **b**](u)").Tokenize()
+	spans := tokens[0].Children
+	if len(spans) != 4 {
+		t.Fatalf("Expected 4 spans, got %s", tree(spans))
+	}
+
+	expected := []Position{
+		positionOf(2, 1, 1, 2, 6, 6),
+		positionOf(2, 6, 6, 2, 12, 12),
+		positionOf(2, 12, 12, 2, 13, 13),
+		positionOf(2, 13, 13, 2, 25, 25),
+	}
+	for i, span := range spans {
+		if span.Pos != expected[i] {
+			t.Errorf("%s at %+v, expected %+v", span.Ttype, span.Pos, expected[i])
+		}
+	}
+
+	strong := spans[3].Children[1]
+	if strong.Pos != positionOf(2, 16, 16, 2, 21, 21) {
+		t.Errorf("Strong within link at %+v", strong.Pos)
+	}
+	if text := strong.Children[0]; text.Pos != positionOf(2, 18, 18, 2, 19, 19) {
+		t.Errorf("Text within strong at %+v", text.Pos)
+	}
+}
+
+func TestNestedListItemPositions(t *testing.T) {
+	tokens := NewParser("- First\n  - Nested").Tokenize()
+	nested := tokens[0].Children[0].Children[1].Children[0]
+	if nested.Pos != positionOf(2, 3, 10, 2, 11, 18) {
+		t.Errorf("Nested item at %+v", nested.Pos)
+	}
+	if tokens[0].Pos != positionOf(1, 1, 0, 2, 11, 18) {
+		t.Errorf("List at %+v", tokens[0].Pos)
+	}
+}
+
+var streamDocuments = []string{
+	"",
+	"# Title",
+	"# Title\n\nparagraph\n",
+	"# Title\nparagraph\n- a\n- b\n  - c\n\n1. one\n2. two\n\n> quote\n> more\n\n---\n```go\ncode\n\nmore code\n```\ntext\n\n\n",
+	"```go\nunclosed\n\ncode",
+	"- loose\n\n  item\n\n- list\n\n\n1. a\n\n   b\ntext",
+	"[a]: /a\n\n# [A]\n\ntext [x][a]\n\n> [b]: /b \"B\"\n\n![b][]",
+	"[^n]: note\n\n    more\n\ntext[^n] and[^N]\n\n- [^m]\n\n[^m]: other",
+	"---\ntitle: Post\n---\n# Title\n\ntext",
+	"---\nnot: [front matter\n---\ntext",
+	"# Intro\n\n## Intro\ntext\n\n> # Intro\n\nIntro\n---",
+	"    code\n\n    more\n\n````{.md}\n```\n\n~~~\n````\n~~~\nunclosed\n",
+	"\\*a\\* &amp; [b\\]](c\\) \"&quot;\")\n\n&copy;\\\n[d]\n\n[d]: &lt;e&gt;",
+	"<div>\n*a*\n\n<pre>\n\n</pre>\ntext <b>x</b>\n<!--\n\n-->",
+}
+
+func TestStreamMatchesTokenize(t *testing.T) {
+	for _, content := range streamDocuments {
+		expected := NewParser(content).Tokenize()
+
+		streamed := []*Token{}
+		err := NewStreamParser(strings.NewReader(content)).Stream(func(token *Token) error {
+			streamed = append(streamed, token)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, _ := json.Marshal(streamed)
+		want, _ := json.Marshal(expected)
+		if string(got) != string(want) {
+			t.Errorf("%q streamed as\n%s\nexpected\n%s", content, got, want)
+		}
+	}
+}
+
+func TestStreamEmitsBeforeEOF(t *testing.T) {
+	reader, writer := io.Pipe()
+	blocks, errs := NewStreamParser(reader).Blocks()
+
+	go io.WriteString(writer, "# First\n\nsecond block\n\n")
+	if first := <-blocks; first.Ttype != Heading1 {
+		t.Errorf("Unexpected first block %s", first.Ttype)
+	}
+
+	go func() {
+		io.WriteString(writer, "third")
+		writer.Close()
+	}()
+	types := []TokenType{}
+	for block := range blocks {
+		types = append(types, block.Ttype)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 2 || types[0] != Paragraph || types[1] != Paragraph {
+		t.Errorf("Unexpected blocks %v", types)
+	}
+}
+
+func TestStreamUnclosedFrontMatter(t *testing.T) {
+	reader, writer := io.Pipe()
+	blocks, errs := NewStreamParser(reader).Blocks()
+
+	done := make(chan bool)
+	go func() {
+		io.WriteString(writer, "---\n"+strings.Repeat("text\n\n", maxFrontMatter))
+		<-done
+		writer.Close()
+	}()
+	if first := <-blocks; first.Ttype != Hr {
+		t.Errorf("Unexpected first block %s", first.Ttype)
+	}
+
+	close(done)
+	count := 1
+	for range blocks {
+		count++
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if count != maxFrontMatter+1 {
+		t.Errorf("Expected %d blocks, got %d", maxFrontMatter+1, count)
+	}
+}
+
+func TestStreamStopsOnError(t *testing.T) {
+	stop := errors.New("stop")
+	calls := 0
+	err := NewStreamParser(strings.NewReader("a\n\nb\n\nc")).Stream(func(token *Token) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("Stream should stop at the first error, %d calls, %v", calls, err)
+	}
+}
+
+func TestMultiLineParagraph(t *testing.T) {
+	tokens := NewParser("first *line*\n  second line\nthird line  \n\nnext").Tokenize()
+	if len(tokens) != 2 {
+		t.Fatalf("Expected 2 paragraphs, got %s", tree(tokens))
+	}
+	expected := `Paragraph("first ", Emphasis("line"), SoftBreak(), "second line", SoftBreak(), "third line")`
+	if result := tree(tokens[:1]); result != expected {
+		t.Errorf("Paragraph parsed as %s", result)
+	}
+	if tokens[0].Pos != positionOf(1, 1, 0, 3, 13, 39) {
+		t.Errorf("Paragraph at %+v", tokens[0].Pos)
+	}
+	if second := tokens[0].Children[3]; second.Pos != positionOf(2, 3, 15, 2, 14, 26) {
+		t.Errorf("Second line at %+v", second.Pos)
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	m := sourceMap{{0, Point{1, 1, 0}}, {4, Point{2, 3, 7}}, {8, Point{3, 1, 13}}}
+	cases := map[int]Point{0: {1, 1, 0}, 3: {1, 4, 3}, 4: {2, 3, 7}, 6: {2, 5, 9}, 8: {3, 1, 13}, 10: {3, 3, 15}}
+	for offset, expected := range cases {
+		if pt := m.at(offset); pt != expected {
+			t.Errorf("Offset %d at %+v, expected %+v", offset, pt, expected)
+		}
+	}
+
+	sliced := m.slice(2, 6)
+	if len(sliced) != 2 || sliced.at(0) != (Point{1, 3, 2}) || sliced.at(3) != (Point{2, 4, 8}) {
+		t.Errorf("Unexpected slice %+v", sliced)
+	}
+}
+
+func TestBackslashEscapes(t *testing.T) {
+	cases := map[string]string{
+		"\\*not italic\\*":              `Paragraph("*not italic*")`,
+		"\\**a**\\\\":                   `Paragraph("*", Emphasis("a"), "*\\")`,
+		"\\[not](link) \\`code`":        "Paragraph(\"[not](link) `code`\")",
+		"\\a \\é \\":                    `Paragraph("\\a \\é \\")`,
+		"a\\\\\nb":                      `Paragraph("a\\", SoftBreak(), "b")`,
+		"[a\\]b](/c\\)d \"\\\"t\\\"\")": `Paragraph(Link("a]b"))`,
+		"\\# \\- \\<a> \\&amp;":         `Paragraph("# - <a> &amp;")`,
+	}
+	for content, expected := range cases {
+		if result := tree(NewParser(content).Tokenize()); result != expected {
+			t.Errorf("%q: expected %s, got %s", content, expected, result)
+		}
+	}
+
+	link := NewParser("[a](/c\\)d \"\\\"t\\\"\")").Tokenize()[0].Children[0]
+	if link.Attrs["url"] != "/c)d" || link.Attrs["title"] != `"t"` {
+		t.Errorf("Unexpected link %v", link.Attrs)
+	}
+
+	text := NewParser("a \\* b").Tokenize()[0].Children[0]
+	if text.Pos != positionOf(1, 1, 0, 1, 7, 6) {
+		t.Errorf("Escaped text at %+v", text.Pos)
+	}
+}
+
+func TestEntities(t *testing.T) {
+	cases := map[string]string{
+		"&amp; &copy; &#x2014; &#35; &#X22;": `& © — # "`,
+		"&#0; &#1114112; &#xD800;":           "\uFFFD \uFFFD \uFFFD",
+		"&ampx; &hl; &#; &#12345678; & a;":   "&ampx; &hl; &#; &#12345678; & a;",
+		"&AElig;&Dcaron;&frac34;&semi;":      "ÆĎ¾;",
+	}
+	for content, expected := range cases {
+		if tokens := NewParser(content).Tokenize(); tree(tokens) != fmt.Sprintf("Paragraph(%q)", expected) {
+			t.Errorf("%q: expected %q, got %s", content, expected, tree(tokens))
+		}
+	}
+
+	if result := tree(NewParser("`&amp;` &#42;a&#42;").Tokenize()); result != `Paragraph(CodeSpan(), " *a*")` {
+		t.Errorf("Entities should be text %s", result)
+	}
+
+	tokens := NewParser("[a](/u?a=1&amp;b=&quot; \"&copy;\") ![&lt;](i&#46;png)\n\n[r]: /&lt; '&amp;'\n\n[r]\n\n```go&#x20;x\n```").Tokenize()
+	link, image := tokens[0].Children[0], tokens[0].Children[2]
+	if link.Attrs["url"] != `/u?a=1&b="` || link.Attrs["title"] != "©" {
+		t.Errorf("Unexpected link %v", link.Attrs)
+	}
+	if image.Attrs["src"] != "i.png" || image.Attrs["alt"] != "<" {
+		t.Errorf("Unexpected image %v", image.Attrs)
+	}
+	if ref := tokens[1].Children[0]; ref.Attrs["url"] != "/<" || ref.Attrs["title"] != "&" {
+		t.Errorf("Unexpected reference link %v", ref.Attrs)
+	}
+	if tokens[2].Attrs["language"] != "go x" {
+		t.Errorf("Unexpected language %q", tokens[2].Attrs["language"])
+	}
+}
+
+func TestHTMLBlocks(t *testing.T) {
+	cases := map[string]string{
+		"<div>\n*not emphasis*\n\n*emphasis*":             `"<div>\n*not emphasis*" Paragraph`,
+		"<pre>\ncode\n\n  kept\n</PRE> tail\nafter":       `"<pre>\ncode\n\n  kept\n</PRE> tail" Paragraph`,
+		"<script src=x></script>\n<style\nb {}\n</style>": `"<script src=x></script>" "<style\nb {}\n</style>"`,
+		"<!-- a -->\n<? b ?>\n<!DOCTYPE html>\ntext":      `"<!-- a -->" "<? b ?>" "<!DOCTYPE html>" Paragraph`,
+		"<![CDATA[\n<x>\n]]>":                             `"<![CDATA[\n<x>\n]]>"`,
+		"text\n<details>\n<summary>S</summary>\n\nb":      `Paragraph "<details>\n<summary>S</summary>" Paragraph`,
+		"</section>\n\n<hr/>":                             `"</section>" "<hr/>"`,
+		"   <custom-el a='1' b>\nx\n\ny":                  `"   <custom-el a='1' b>\nx" Paragraph`,
+		"text\n<custom-el>\nmore":                         `Paragraph`,
+		"    <div>":                                       `CodeBloc`,
+		"<divx>\n\n<span>text</span>\n\n<a b=>":           `"<divx>" Paragraph Paragraph`,
+		"> <div>\n> *a*\n\n- <!--\n  b -->":               `Blockquote UnorderedList`,
+		"<?\n\n":                                          `"<?"`,
+	}
+	for content, expected := range cases {
+		blocks := []string{}
+		for _, token := range NewParser(content).Tokenize() {
+			if token.Ttype == HTMLBlock {
+				blocks = append(blocks, fmt.Sprintf("%q", token.Value))
+			} else {
+				blocks = append(blocks, string(token.Ttype))
+			}
+		}
+		if result := strings.Join(blocks, " "); result != expected {
+			t.Errorf("%q: expected %s, got %s", content, expected, result)
+		}
+	}
+
+	tokens := NewParser("> <div>\n> *a*").Tokenize()
+	if result := tree(tokens); result != "Blockquote(HTMLBlock())" || tokens[0].Children[0].Value != "<div>\n*a*" {
+		t.Errorf("Expected an HTML block in the quote %s", result)
+	}
+
+	tokens, diagnostics := NewParser("text\n\n<!-- unclosed\n\nstill").TokenizeWithDiagnostics()
+	if len(tokens) != 2 || tokens[1].Value != "<!-- unclosed\n\nstill" || fmt.Sprint(diagnostics) != "[3:1: HTML block is not closed]" {
+		t.Errorf("Unclosed HTML block should run to the end %s %v", tree(tokens), diagnostics)
+	}
+	if end := tokens[1].Pos.End; end.Line != 5 || end.Column != 6 {
+		t.Errorf("HTML block should end with the document %v", end)
+	}
+}
+
+func TestInlineHTML(t *testing.T) {
+	cases := map[string]string{
+		"a <b>*c*</b>":                         `"a ", HTMLInline(), Emphasis("c"), HTMLInline()`,
+		"a <span class=\"x*y*\" data-a='*' c>": `"a ", HTMLInline()`,
+		"a <br/> <br /> <a\nhref=x>":           `"a ", HTMLInline(), " ", HTMLInline(), " ", HTMLInline()`,
+		"a <!-- *c* --> <?p *x* ?> <!X *y*>":   `"a ", HTMLInline(), " ", HTMLInline(), " ", HTMLInline()`,
+		"a <![CDATA[*x*]]> <!--> <!--->":       `"a ", HTMLInline(), " ", HTMLInline(), " ", HTMLInline()`,
+		"a <33> <a b=> <a b='c> </a b> <!--x":  `"a <33> <a b=> <a b='c> </a b> <!--x"`,
+		"`<b>` \\<b>":                          `CodeSpan(), " <b>"`,
+		"<https://a.b> <a:b>":                  `Link("https://a.b"), " <a:b>"`,
+	}
+	for content, expected := range cases {
+		if result := tree(NewParser(content).Tokenize()[0].Children); result != expected {
+			t.Errorf("%q: expected %s, got %s", content, expected, result)
+		}
+	}
+
+	span := NewParser("a <b\n c='*'>").Tokenize()[0].Children[1]
+	if span.Value != "<b\nc='*'>" || span.Pos != positionOf(1, 3, 2, 2, 8, 12) {
+		t.Errorf("Unexpected inline HTML %q at %+v", span.Value, span.Pos)
+	}
+}
+
+func TestCodeSpans(t *testing.T) {
+	cases := map[string]string{
+		"`a_b_c` and *`*`*":      `Paragraph(CodeSpan(), " and ", Emphasis(CodeSpan()))`,
+		"`` a ` b ``":            `Paragraph(CodeSpan())`,
+		"a ```unclosed`` `x`":    "Paragraph(\"a ```unclosed`` \", CodeSpan())",
+		"[not a `link](/foo`)":   `Paragraph("[not a ", CodeSpan(), ")")`,
+		"[`a]` link](url)":       `Paragraph(Link(CodeSpan(), " link"))`,
+		"`line  \nbreak` \\`no`": "Paragraph(CodeSpan(), \" `no`\")",
+	}
+	for content, expected := range cases {
+		if result := tree(NewParser(content).Tokenize()); result != expected {
+			t.Errorf("%q: expected %s, got %s", content, expected, result)
+		}
+	}
+
+	values := map[string]string{
+		"`a_b_c`":      "a_b_c",
+		"`` a ` b ``":  "a ` b",
+		"` `` `":       "``",
+		"`  two  `":    " two ",
+		"`   `":        "   ",
+		"`a\nb`":       "a b",
+		"`trailing  `": "trailing  ",
+	}
+	for content, expected := range values {
+		code := NewParser(content).Tokenize()[0].Children[0]
+		if code.Ttype != CodeSpan || code.Value != expected {
+			t.Errorf("%q: expected code %q, got %s %q", content, expected, code.Ttype, code.Value)
+		}
+	}
+
+	code := NewParser("a `` b ``").Tokenize()[0].Children[1]
+	if code.Pos != positionOf(1, 3, 2, 1, 10, 9) {
+		t.Errorf("Code span at %+v", code.Pos)
+	}
+}
+
+func TestAutolinks(t *testing.T) {
+	cases := map[string]string{
+		"<https://example.com/a_b_c>":   "https://example.com/a_b_c",
+		"<irc://foo.bar:2233/baz>":      "irc://foo.bar:2233/baz",
+		"<user.name+tag@example.co.uk>": "mailto:user.name+tag@example.co.uk",
+	}
+	for content, url := range cases {
+		link := NewParser(content).Tokenize()[0].Children[0]
+		if link.Ttype != Link || link.Attrs["url"] != url || link.Attrs["autolink"] != true {
+			t.Errorf("%q: expected an autolink to %s, got %s %v", content, url, link.Ttype, link.Attrs)
+		}
+		if result := tree(link.Children); result != fmt.Sprintf("%q", content[1:len(content)-1]) {
+			t.Errorf("%q: autolink text is %s", content, result)
+		}
+	}
+
+	for _, content := range []string{"<not a link!>", "<x:y>", "<1a:b>", "<a@b_c.com>", "<https://a.com", "a < b > c"} {
+		if result := tree(NewParser(content).Tokenize()); result != fmt.Sprintf("Paragraph(%q)", content) {
+			t.Errorf("%q should be text, got %s", content, result)
+		}
+	}
+
+	link := NewParser("see <a@b.co>").Tokenize()[0].Children[1]
+	if link.Pos != positionOf(1, 5, 4, 1, 13, 12) || link.Children[0].Pos != positionOf(1, 6, 5, 1, 12, 11) {
+		t.Errorf("Autolink at %+v, text at %+v", link.Pos, link.Children[0].Pos)
+	}
+}
+
+func TestReferenceLinks(t *testing.T) {
+	content := "[full][Ref], [collapsed][], [shortcut] and ![alt][ref]\n\n" +
+		"[ref]: /url \"Title\"\n[Collapsed]: </a b>\n> [shortcut]:  https://x.org  'X'\n\n" +
+		"[ref]: /ignored\n"
+	doc := NewParser(content).Parse()
+	if len(doc.Tokens) != 2 || doc.Tokens[1].Ttype != Blockquote || len(doc.Tokens[1].Children) != 0 {
+		t.Fatalf("Definitions should leave no token, got %s", tree(doc.Tokens))
+	}
+
+	expected := map[string]Reference{
+		"ref":       {URL: "/url", Title: "Title"},
+		"collapsed": {URL: "/a b"},
+		"shortcut":  {URL: "https://x.org", Title: "X"},
+	}
+	if len(doc.References) != len(expected) {
+		t.Errorf("Unexpected references %v", doc.References)
+	}
+	for label, ref := range expected {
+		if doc.References[label] != ref {
+			t.Errorf("Reference %q is %+v, expected %+v", label, doc.References[label], ref)
+		}
+	}
+
+	spans := doc.Tokens[0].Children
+	if result := tree(spans); result != `Link("full"), ", ", Link("collapsed"), ", ", Link("shortcut"), " and ", Image()` {
+		t.Errorf("Unexpected spans %s", result)
+	}
+	if spans[0].Attrs["url"] != "/url" || spans[0].Attrs["title"] != "Title" || spans[2].Attrs["url"] != "/a b" {
+		t.Errorf("Unexpected link attributes %v %v", spans[0].Attrs, spans[2].Attrs)
+	}
+	if image := spans[6]; image.Attrs["src"] != "/url" || image.Attrs["alt"] != "alt" || image.Attrs["title"] != "Title" {
+		t.Errorf("Unexpected image attributes %v", image.Attrs)
+	}
+	if spans[0].Pos != positionOf(1, 1, 0, 1, 12, 11) || spans[2].Pos != positionOf(1, 14, 13, 1, 27, 26) {
+		t.Errorf("Links at %+v and %+v", spans[0].Pos, spans[2].Pos)
+	}
+}
+
+func TestReferenceDefinedLater(t *testing.T) {
+	content := "- [Nested  Label]\n\n> [nested label]: /n\n\n[x][y] [z]\n\n[Z]: /z"
+	tokens := NewParser(content).Tokenize()
+	link := tokens[0].Children[0].Children[0].Children[0]
+	if link.Ttype != Link || link.Attrs["url"] != "/n" {
+		t.Errorf("Expected a link to /n, got %s %v", link.Ttype, link.Attrs)
+	}
+	if result := tree(tokens[2].Children); result != `"[x][y] ", Link("z")` {
+		t.Errorf("Unexpected spans %s", result)
+	}
+}
+
+func TestNotDefinition(t *testing.T) {
+	for _, content := range []string{"[a]:", "[a]: /url \"title", "[a]: /url title", "text\n    [a]: /url", "[]: /url", "text\n[a]: /url"} {
+		tokens := NewParser(content).Tokenize()
+		if len(tokens) != 1 || tokens[0].Ttype != Paragraph {
+			t.Errorf("%q should be a paragraph, got %s", content, tree(tokens))
+		}
+	}
+}
+
+func TestLinkTitles(t *testing.T) {
+	cases := map[string][2]string{
+		`[a](/url "the title")`: {"/url", "the title"},
+		`[a]( /url 'single' )`:  {"/url", "single"},
+		`[a](/url"no")`:         {`/url"no"`, ""},
+		`[a](/wiki/Go_(lang))`:  {"/wiki/Go_(lang)", ""},
+		`[a](/a(b(c)) "t)")`:    {"/a(b(c))", "t)"},
+		`[a](</b)c> 'd(')`:      {"/b)c", "d("},
+		`[a](/b\(c)`:            {"/b(c", ""},
+	}
+	for content, expected := range cases {
+		link := NewParser(content).Tokenize()[0].Children[0]
+		title, _ := link.Attrs["title"].(string)
+		if link.Attrs["url"] != expected[0] || title != expected[1] {
+			t.Errorf("%q: expected %q titled %q, got %v", content, expected[0], expected[1], link.Attrs)
+		}
+	}
+
+	if result := tree(NewParser("[a](/b(c)").Tokenize()); result != `Paragraph("[a](/b(c)")` {
+		t.Errorf("Unbalanced parentheses should not make a link %s", result)
+	}
+}
+
+func TestFootnotes(t *testing.T) {
+	content := "Text[^b] and[^a], again[^B].\n\n[^a]: First\n[^b]: Second with[^c]\n\n    > more\n\n[^c]: Third\n[^unused]: none\n\n[^x]"
+	tokens := NewParser(content).Tokenize()
+	if len(tokens) != 3 || tokens[2].Ttype != Footnotes {
+		t.Fatalf("Expected the footnotes to end the document, got %s", tree(tokens))
+	}
+	if result := tree(tokens[1].Children); result != `"[^x]"` {
+		t.Errorf("An undefined footnote should be text, got %s", result)
+	}
+
+	refs := []*Token{}
+	for _, token := range tokens[0].Children {
+		if token.Ttype == FootnoteRef {
+			refs = append(refs, token)
+		}
+	}
+	expected := [][3]any{{"b", 1, 1}, {"a", 2, 1}, {"B", 1, 2}}
+	if len(refs) != len(expected) {
+		t.Fatalf("Unexpected references %s", tree(tokens[0].Children))
+	}
+	for i, ref := range refs {
+		if ref.Value != expected[i][0] || ref.Attrs["number"] != expected[i][1] || ref.Attrs["reference"] != expected[i][2] {
+			t.Errorf("Reference %d is %q %v", i, ref.Value, ref.Attrs)
+		}
+	}
+	if refs[0].Pos != positionOf(1, 5, 4, 1, 9, 8) {
+		t.Errorf("Reference at %+v", refs[0].Pos)
+	}
+
+	definitions := tokens[2].Children
+	if len(definitions) != 3 {
+		t.Fatalf("Unexpected definitions %s", tree(definitions))
+	}
+	for i, label := range []string{"b", "a", "c"} {
+		definition := definitions[i]
+		if definition.Ttype != FootnoteDefinition || definition.Value != label || definition.Attrs["number"] != i+1 {
+			t.Errorf("Definition %d is %s %q %v", i, definition.Ttype, definition.Value, definition.Attrs)
+		}
+	}
+	if definitions[0].Attrs["references"] != 2 || definitions[2].Attrs["references"] != 1 {
+		t.Errorf("Unexpected reference counts %v %v", definitions[0].Attrs, definitions[2].Attrs)
+	}
+	if result := tree(definitions[0].Children); result != `Paragraph("Second with", FootnoteRef()), Blockquote(Paragraph("more"))` {
+		t.Errorf("Unexpected footnote content %s", result)
+	}
+	if definitions[0].Pos != positionOf(4, 1, 42, 6, 11, 75) {
+		t.Errorf("Definition at %+v", definitions[0].Pos)
+	}
+}
+
+func TestFrontMatter(t *testing.T) {
+	cases := map[string]string{
+		"---\ntitle: \"A: post\" # quoted\ndraft: false\ntags: [go, 'mark, down']\nauthor:\n  name: Ann\n  links:\n  - site\n  - id: 3\n    score: 1.5\nempty:\n...\n# Title": `{"author":{"links":["site",{"id":3,"score":1.5}],"name":"Ann"},"draft":false,"empty":null,"tags":["go","mark, down"],"title":"A: post"}`,
+		"+++\ntitle = \"Post\" # comment\ndate = 2024-01-02\nsizes = [1, 2_000, 3.5,]\n[params.seo]\nindex = true\n[[menu]]\nname = 'main'\n+++\n# Title":                     `{"date":"2024-01-02","menu":[{"name":"main"}],"params":{"seo":{"index":true}},"sizes":[1,2000,3.5],"title":"Post"}`,
+		"{\n  \"title\": \"Post\",\n  \"tags\": [\"a\"]\n}\n# Title": `{"tags":["a"],"title":"Post"}`,
+		"---\n---\n# Title": `{}`,
+	}
+	for content, expected := range cases {
+		doc := NewParser(content).Parse()
+		if meta, _ := json.Marshal(doc.Meta); string(meta) != expected {
+			t.Errorf("%q: expected metadata %s, got %s", content, expected, meta)
+		}
+		if len(doc.Tokens) != 1 || doc.Tokens[0].Ttype != Heading1 || doc.Tokens[0].Pos.Start.Column != 1 {
+			t.Errorf("%q: front matter should be left out, got %s", content, tree(doc.Tokens))
+		}
+	}
+
+	for _, content := range []string{"---\nsome text\n---", "---\nunclosed: true", "+++\nkey value\n+++", "{\"a\": 1} text", "---\n  bad: indent\nkey: 1\n---", "\n---\na: 1\n---", "+++\na = []\n[a.b]\n+++"} {
+		doc := NewParser(content).Parse()
+		if doc.Meta != nil || len(doc.Tokens) < 2 && doc.Tokens[0].Ttype != Paragraph {
+			t.Errorf("%q: expected no front matter, got %v %s", content, doc.Meta, tree(doc.Tokens))
+		}
+	}
+
+	doc, diagnostics := NewParser("+++\na = 1\n+++\ntext").ParseWithDiagnostics()
+	if doc.FrontMatter != "+++\na = 1\n+++" || len(diagnostics) != 0 {
+		t.Errorf("Unexpected front matter %q %v", doc.FrontMatter, diagnostics)
+	}
+	doc, diagnostics = NewParser("---\ndescription: >\n  text\n---\ntext").ParseWithDiagnostics()
+	if doc.FrontMatter != "" || len(diagnostics) != 1 || diagnostics[0].String() != "1:1: YAML front matter doesn't parse, read as Markdown" {
+		t.Errorf("Invalid front matter should be reported %q %v", doc.FrontMatter, diagnostics)
+	}
+
+	s := NewStreamParser(strings.NewReader("---\ntitle: Post\n---\ntext"))
+	if err := s.Stream(func(*Token) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if s.Meta()["title"] != "Post" {
+		t.Errorf("Stream front matter is %v", s.Meta())
+	}
+}
+
+func TestThematicBreaks(t *testing.T) {
+	for _, content := range []string{"---", "***", "___", "- - -", " **  * ", "   -----", "_\t_ _", "***\t"} {
+		if result := tree(NewParser(content).Tokenize()); result != "Hr()" {
+			t.Errorf("%q: expected a thematic break, got %s", content, result)
+		}
+	}
+	for _, content := range []string{"===", "--", "**", "-*-", "    ---", "--- a", "_ _ _ x"} {
+		if tokens := NewParser(content).Tokenize(); len(tokens) != 1 || tokens[0].Ttype == Hr {
+			t.Errorf("%q: expected no thematic break, got %s", content, tree(tokens))
+		}
+	}
+}
+
+func TestSetextHeadings(t *testing.T) {
+	cases := map[string]string{
+		"Title\n=====":            `Heading1("Title")`,
+		"Title\n-":                `Heading2("Title")`,
+		"*Multi*\nline\n  ===  ":  `Heading1(Emphasis("Multi"), SoftBreak(), "line")`,
+		"Title\n---\ntext":        `Heading2("Title"), Paragraph("text")`,
+		"Title\n    ===":          `Paragraph("Title", SoftBreak(), "===")`,
+		"Title\n= =":              `Paragraph("Title", SoftBreak(), "= =")`,
+		"- item\n---":             `UnorderedList(UnorderedListItem(Paragraph("item"))), Hr()`,
+		"> quote\n---":            `Blockquote(Paragraph("quote")), Hr()`,
+		"- item\n  ---":           `UnorderedList(UnorderedListItem(Heading2("item")))`,
+		"- item\n\n  ---":         `UnorderedList(UnorderedListItem(Paragraph("item"), Hr()))`,
+		"- item\n  text\n  ---":   `UnorderedList(UnorderedListItem(Heading2("item", SoftBreak(), "text")))`,
+		"===\n\ntext\n***\n\n===": `Paragraph("==="), Paragraph("text"), Hr(), Paragraph("===")`,
+	}
+	for content, expected := range cases {
+		if result := tree(NewParser(content).Tokenize()); result != expected {
+			t.Errorf("%q: expected %s, got %s", content, expected, result)
+		}
+	}
+
+	heading := NewParser("a\nb\n==").Tokenize()[0]
+	if heading.Value != "a\nb" || heading.Pos != positionOf(1, 1, 0, 3, 3, 6) {
+		t.Errorf("Heading %q at %+v", heading.Value, heading.Pos)
+	}
+}
+
+func TestSlugs(t *testing.T) {
+	cases := map[string][3]string{
+		"Hello, World!":         {"hello-world", "hello-world", "hello-world"},
+		"  Two  spaces - here ": {"--two--spaces---here-", "two-spaces-here", "two-spaces---here"},
+		"v1.2 snake_case Été":   {"v12-snake_case-été", "v12-snake_case-été", "v1.2-snake_case-été"},
+		"2024: A *review*?":     {"2024-a-review", "2024-a-review", "a-review"},
+		"!!!":                   {"", "", "section"},
+	}
+	for text, expected := range cases {
+		for i, style := range []SlugStyle{SlugGitHub, SlugGitLab, SlugPandoc} {
+			if slug := Slug(text, style); slug != expected[i] {
+				t.Errorf("%q: expected slug %q in style %d, got %q", text, expected[i], style, slug)
+			}
+		}
+	}
+
+	if _, err := ParseSlugStyle("GitLab"); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParseSlugStyle("wiki"); err == nil {
+		t.Error("Expected an error for an unknown slug style")
+	}
+}
+
+func TestHeadingIDs(t *testing.T) {
+	tokens := NewParser("# Intro\n\n## Intro\n\n> ### *Intro*\n\nIntro 1\n===\n\n# `code` [link](u)\n\n# !!!").Tokenize()
+	ids := []any{}
+	var walk func(tokens []*Token)
+	walk = func(tokens []*Token) {
+		for _, token := range tokens {
+			if HeadingLevel(token) > 0 {
+				ids = append(ids, token.Attrs["id"])
+			}
+			walk(token.Children)
+		}
+	}
+	walk(tokens)
+	if result := fmt.Sprint(ids); result != "[intro intro-1 intro-2 intro-1-1 code-link <nil>]" {
+		t.Errorf("Unexpected heading ids %s", result)
+	}
+
+	heading := NewParser("## Étape 2. Go").WithSlugStyle(SlugPandoc).Tokenize()[0]
+	if heading.Attrs["id"] != "étape-2.-go" {
+		t.Errorf("Unexpected pandoc id %v", heading.Attrs["id"])
+	}
+}
+
+func TestLinkify(t *testing.T) {
+	cases := map[string]string{
+		"visit www.example.com/path.":                "http://www.example.com/path",
+		"(see https://example.com/a_(b))":            "https://example.com/a_(b)",
+		"go to http://example.com/?q=1&hl;, now":     "http://example.com/?q=1",
+		"write to foo.bar+baz@example.org.":          "mailto:foo.bar+baz@example.org",
+		"*www.commonmark.org/he<lp*":                 "http://www.commonmark.org/he",
+		"https://www.example.com/search?q=(a)(b))))": "https://www.example.com/search?q=(a)(b)",
+	}
+	for content, url := range cases {
+		links := []string{}
+		var walk func(tokens []*Token)
+		walk = func(tokens []*Token) {
+			for _, token := range tokens {
+				if token.Ttype == Link {
+					links = append(links, fmt.Sprint(token.Attrs["url"]))
+				}
+				walk(token.Children)
+			}
+		}
+		walk(NewParser(content).WithExtensions(ExtLinkify).Tokenize())
+		if len(links) != 1 || links[0] != url {
+			t.Errorf("%q: expected a link to %s, got %v", content, url, links)
+		}
+	}
+
+	texts := []string{"www. example", "awww.example.com", "http://a_b.example_c.com", "a@b", "a@b.c-", "@example.com", "[www.example.com](url)", "`www.example.com`"}
+	for _, content := range texts {
+		tokens := NewParser(content).WithExtensions(ExtLinkify).Tokenize()
+		expected := tree(NewParser(content).Tokenize())
+		if result := tree(tokens); result != expected {
+			t.Errorf("%q shouldn't be linkified, got %s", content, result)
+		}
+	}
+
+	tokens := NewParser("a www.b.com c").WithExtensions(ExtLinkify).Tokenize()
+	expected := `Paragraph("a ", Link("www.b.com"), " c")`
+	if result := tree(tokens); result != expected {
+		t.Errorf("Linkified as %s", result)
+	}
+	if link := tokens[0].Children[1]; link.Pos != positionOf(1, 3, 2, 1, 12, 11) {
+		t.Errorf("Link at %+v", link.Pos)
+	}
+	if tree(NewParser("a www.b.com c").Tokenize()) != `Paragraph("a www.b.com c")` {
+		t.Error("Text should only be linkified with the extension")
+	}
+
+	tokens = NewParser("\\*e &amp; www.x.com &copy;").WithExtensions(ExtLinkify).Tokenize()
+	if link := tokens[0].Children[1]; link.Pos != positionOf(1, 11, 10, 1, 20, 19) {
+		t.Errorf("Link after escapes at %+v", link.Pos)
+	}
+	if rest := tokens[0].Children[2]; rest.Pos != positionOf(1, 20, 19, 1, 27, 26) {
+		t.Errorf("Text after the link at %+v", rest.Pos)
+	}
+}
+
+func TestExtensionSpans(t *testing.T) {
+	all := ExtStrikethrough | ExtHighlight | ExtSubscript | ExtSuperscript
+	cases := []struct {
+		content  string
+		ext      Extensions
+		expected string
+	}{
+		{"~~gone~~ and ==marked==", all, `Paragraph(Strikethrough("gone"), " and ", Highlight("marked"))`},
+		{"H~2~O and 2^10^", all, `Paragraph("H", Subscript("2"), "O and 2", Superscript("10"))`},
+		{"~~*both*~~ **==in==**", all, `Paragraph(Strikethrough(Emphasis("both")), " ", Strong(Highlight("in")))`},
+		{"~~a~ b~~~ c^", all, `Paragraph("~~a~ b~~~ c^")`},
+		{"~not a sub~ and a == b == c", all, `Paragraph("~not a sub~ and a == b == c")`},
+		{"~~gone~~ H~2~O", ExtSubscript, `Paragraph("~~gone~~ H", Subscript("2"), "O")`},
+		{"~~gone~~ H~2~O", ExtStrikethrough, `Paragraph(Strikethrough("gone"), " H~2~O")`},
+		{"~~gone~~ ==marked== ^sup^", NoExtensions, `Paragraph("~~gone~~ ==marked== ^sup^")`},
+		{"[~~a~~](b)", all, `Paragraph(Link(Strikethrough("a")))`},
+	}
+
+	for _, c := range cases {
+		if result := tree(NewParser(c.content).WithExtensions(c.ext).Tokenize()); result != c.expected {
+			t.Errorf("%q with %v: expected %s, got %s", c.content, c.ext, c.expected, result)
+		}
+	}
+
+	tokens := NewParser("a ~~b~~").WithExtensions(all).Tokenize()
+	if strike := tokens[0].Children[1]; strike.Pos != positionOf(1, 3, 2, 1, 8, 7) {
+		t.Errorf("Strikethrough at %+v", strike.Pos)
+	}
+}
+
+func TestHardBreaks(t *testing.T) {
+	tokens := NewParser("two spaces  \nbackslash\\\nsoft \nend\\").Tokenize()
+	expected := `Paragraph("two spaces", HardBreak(), "backslash", HardBreak(), "soft", SoftBreak(), "end\\")`
+	if result := tree(tokens); result != expected {
+		t.Errorf("Breaks parsed as %s", result)
+	}
+}
+
+func TestParagraphInterruptedByBlocks(t *testing.T) {
+	tokens := NewParser("text\n# Heading\ntext\n- item\n\ntext\n> quote\n\ntext\n***\ntext\n```go\ncode\n```").Tokenize()
+	types := []TokenType{}
+	for _, token := range tokens {
+		types = append(types, token.Ttype)
+	}
+	expected := "[Paragraph Heading1 Paragraph UnorderedList Paragraph Blockquote Paragraph Hr Paragraph CodeBloc]"
+	if fmt.Sprint(types) != expected {
+		t.Errorf("Blocks parsed as %v", types)
+	}
 }